@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic remplace dst par le contenu de r sans jamais exposer de
+// fichier tronqué : écriture dans un fichier temporaire voisin, fsync,
+// application du mode et du propriétaire, puis rename. La version précédente
+// est conservée sous dst.bak pour permettre un retour arrière.
+func writeFileAtomic(dst string, r io.Reader, mode os.FileMode) error {
+	return replaceFileAtomic(dst, r, mode, true)
+}
+
+// replaceFileAtomic implémente writeFileAtomic ; backup indique s'il faut
+// conserver la version précédente sous dst.bak
+func replaceFileAtomic(dst string, r io.Reader, mode os.FileMode, backup bool) error {
+	dir := filepath.Dir(dst)
+
+	// Le fichier temporaire doit être sur le même système de fichiers que dst
+	// pour que le rename soit atomique
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("impossible de créer un fichier temporaire dans %s : %w", dir, err)
+	}
+	tmpPath := tmp.Name()
+
+	// En cas d'échec à n'importe quelle étape, ne pas laisser de fichier orphelin
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", tmpPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("impossible de synchroniser %s : %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("impossible de fermer %s : %w", tmpPath, err)
+	}
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("impossible d'appliquer les permissions sur %s : %w", tmpPath, err)
+	}
+
+	// Conserver le propriétaire de la version existante (ex: root:smartsentry)
+	if existing, err := os.Stat(dst); err == nil {
+		if uid, gid, ok := fileOwner(existing); ok {
+			if err := os.Chown(tmpPath, uid, gid); err != nil {
+				return fmt.Errorf("impossible d'appliquer le propriétaire sur %s : %w", tmpPath, err)
+			}
+		}
+
+		if backup {
+			if err := backupFile(dst); err != nil {
+				return fmt.Errorf("impossible de sauvegarder %s : %w", dst, err)
+			}
+		}
+	}
+
+	// rename remplace l'entrée du répertoire : un binaire en cours d'exécution
+	// garde son ancien inode, ce qui évite ETXTBSY
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return fmt.Errorf("impossible de remplacer %s : %w", dst, err)
+	}
+	committed = true
+
+	return syncDirectory(dir)
+}
+
+// writeBytesAtomic est une variante de writeFileAtomic pour un contenu en mémoire
+func writeBytesAtomic(dst string, data []byte, mode os.FileMode) error {
+	return writeFileAtomic(dst, bytes.NewReader(data), mode)
+}
+
+// writeBytesAtomicWithoutBackup remplace dst sans en conserver de copie
+// voisine, pour les répertoires dont chaque fichier est interprété (ex:
+// /etc/init.d, où un .bak serait vu comme un second service)
+func writeBytesAtomicWithoutBackup(dst string, data []byte, mode os.FileMode) error {
+	return replaceFileAtomic(dst, bytes.NewReader(data), mode, false)
+}
+
+// backupFile conserve la version actuelle de path sous path.bak
+func backupFile(path string) error {
+	backupPath := path + ".bak"
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Un lien physique est instantané et ne duplique pas le binaire ;
+	// à défaut (système de fichiers sans liens), on copie le contenu
+	if err := os.Link(path, backupPath); err == nil {
+		return nil
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	backup, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(backup, source); err != nil {
+		backup.Close()
+		os.Remove(backupPath)
+		return err
+	}
+	return backup.Close()
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// fileOwner stub pour Windows - les propriétaires sont gérés par les ACL
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// syncDirectory stub pour Windows - les répertoires ne peuvent pas être synchronisés
+func syncDirectory(dir string) error {
+	return nil
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 // setupConfiguration télécharge et installe la configuration de l'agent
-// selon l'OS détecté, puis demande à l'utilisateur l'adresse du Gateway
-func setupConfiguration() error {
+// selon l'OS détecté, puis demande à l'utilisateur l'adresse du Gateway.
+// Une configuration déjà présente n'est jamais écrasée sans l'accord de
+// l'utilisateur (ou de --config-policy).
+func setupConfiguration(opts installOptions) error {
 	// Déterminer le répertoire de configuration selon l'OS
 	configDir, err := getConfigDirectory()
 	if err != nil {
@@ -23,36 +30,154 @@ func setupConfiguration() error {
 		return fmt.Errorf("impossible de créer le répertoire %s : %w", configDir, err)
 	}
 
-	fmt.Printf("📁 Configuration dans : %s\n", configDir)
+	slog.Info("Répertoire de configuration", "path", configDir)
 
-	// Télécharger la configuration par défaut selon l'OS
-	configURL := getDefaultConfigURL()
 	configPath := filepath.Join(configDir, "config.yaml")
 
-	fmt.Printf("📥 Téléchargement de la configuration depuis : %s\n", configURL)
-	if err := downloadFile(configURL, configPath); err != nil {
+	// Détecter une configuration existante (ré-installation)
+	existing, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("impossible de lire %s : %w", configPath, err)
+	}
+	hasExisting := err == nil
+
+	if hasExisting && opts.ConfigPolicy == CONFIG_POLICY_KEEP {
+		slog.Info("Configuration existante conservée", "path", configPath)
+		return nil
+	}
+
+	// Télécharger la configuration par défaut selon l'OS (ou lire le modèle local)
+	var template []byte
+	if opts.ConfigTemplate != "" {
+		slog.Info("Modèle de configuration local", "path", opts.ConfigTemplate)
+		template, err = os.ReadFile(opts.ConfigTemplate)
+	} else {
+		configURL := getDefaultConfigURL()
+		slog.Info("Téléchargement de la configuration", "url", configURL)
+		template, err = fetchURL(configURL)
+	}
+	if err != nil {
 		return fmt.Errorf("échec du téléchargement de la configuration : %w", err)
 	}
 
-	// Demander l'adresse du SmartSentry Gateway à l'utilisateur
-	gatewayURL, err := promptForGatewayURL()
+	// Demander l'adresse du SmartSentry Gateway à l'utilisateur si --gateway est absent
+	gatewayURL := normalizeGatewayURL(opts.GatewayURL)
+	if gatewayURL == "" {
+		gatewayURL, err = promptForGatewayURL()
+		if err != nil {
+			return fmt.Errorf("erreur lors de la saisie du Gateway : %w", err)
+		}
+	}
+
+	// Sous systemd, l'endpoint et le jeton vivent dans agent.env (EnvironmentFile=)
+	// pour que config.yaml reste lisible par tous et qu'une rotation de jeton
+	// ne nécessite qu'une modification de ce fichier
+	useEnvFile := runtime.GOOS == "linux"
+
+	renderOpts := renderOptions{
+		GatewayURL:   gatewayURL,
+		Token:        opts.Token,
+		CAFile:       opts.CAFile,
+		UseEnvFile:   useEnvFile,
+		Profile:      opts.Profile,
+		TargetOS:     runtime.GOOS,
+		Tags:         opts.Tags,
+		UserMode:     userMode,
+		Container:    opts.Runtime == RUNTIME_CONTAINER,
+		EnablePprof:  opts.EnablePprof,
+		EnableZPages: opts.EnableZPages,
+	}
+	// L'identifiant de l'agent survit aux mises à jour et réinstallations ;
+	// un identifiant nouveau (premier rendu ou --reset-identity) n'est
+	// enregistré qu'une fois écrite la configuration qui l'utilise
+	agentID, agentIDStored, err := loadAgentID()
 	if err != nil {
-		return fmt.Errorf("erreur lors de la saisie du Gateway : %w", err)
+		return fmt.Errorf("identifiant de l'agent indisponible : %w", err)
+	}
+	if opts.ResetIdentity {
+		if agentID, err = newUUIDv7(); err != nil {
+			return fmt.Errorf("impossible de générer l'identifiant de l'agent : %w", err)
+		}
+		agentIDStored = false
+	}
+	renderOpts.InstanceUID = agentID
+	renderOpts.MachineID = readMachineID()
+	rendered, err := renderConfig(template, renderOpts)
+	if err != nil {
+		return fmt.Errorf("impossible de générer la configuration : %w", err)
+	}
+	slog.Info("Extensions de diagnostic", "extensions", describeExtensions(renderOpts))
+
+	if hasExisting {
+		// En cas de fusion, les options explicites l'emportent sur l'existant
+		overrides := renderOptions{UseEnvFile: useEnvFile, CAFile: opts.CAFile, Tags: opts.Tags, InstanceUID: renderOpts.InstanceUID, MachineID: renderOpts.MachineID}
+		if opts.GatewayURL != "" || opts.Token != "" {
+			overrides.GatewayURL, overrides.Token = gatewayURL, opts.Token
+		}
+		rendered, err = resolveExistingConfig(configPath, existing, rendered, opts.ConfigPolicy, overrides)
+		if err != nil {
+			return err
+		}
+		if rendered == nil {
+			slog.Info("Configuration existante conservée", "path", configPath)
+			if opts.ResetIdentity {
+				slog.Warn("Identifiant de l'agent conservé : il n'est réinitialisé qu'avec une nouvelle configuration (--config-policy replace, backup ou merge)")
+			}
+			return nil
+		}
+	}
+
+	// Retenir la configuration en service pour un éventuel retour arrière
+	prepareConfigRollback()
+
+	if useEnvFile {
+		if err := writeAgentEnvFile(gatewayURL, opts.Token, opts.OpAMPToken); err != nil {
+			return fmt.Errorf("impossible d'écrire le fichier d'environnement : %w", err)
+		}
 	}
 
-	// Mettre à jour la configuration avec l'URL du Gateway
-	if err := updateConfigWithGateway(configPath, gatewayURL); err != nil {
-		return fmt.Errorf("impossible de mettre à jour la configuration : %w", err)
+	// config.yaml identique (endpoint et jetons référencés depuis agent.env) :
+	// seul agent.env change
+	if !hasExisting || !bytes.Equal(rendered, existing) {
+		// Remplacer la configuration atomiquement
+		if err := writeBytesAtomic(configPath, rendered, 0644); err != nil {
+			return fmt.Errorf("impossible d'écrire la configuration : %w", err)
+		}
+		slog.Info("Configuration mise à jour", "path", configPath)
 	}
 
-	fmt.Println("✅ Configuration mise à jour avec succès")
+	if !agentIDStored {
+		return saveAgentID(agentID, opts.ResetIdentity)
+	}
 	return nil
 }
 
+// writeAgentEnvFile enregistre l'endpoint du Gateway et les jetons dans agent.env
+func writeAgentEnvFile(gatewayURL, token, opampToken string) error {
+	envPath, err := getEnvFilePath()
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{ENV_GATEWAY_ENDPOINT: gatewayURL}
+	if token != "" {
+		values[ENV_TOKEN] = token
+	}
+	if opampToken != "" {
+		values[ENV_OPAMP_TOKEN] = opampToken
+	}
+
+	slog.Info("Endpoint et secrets enregistrés", "path", envPath)
+	return updateEnvFile(envPath, values)
+}
+
 // getConfigDirectory retourne le répertoire de configuration selon l'OS
 func getConfigDirectory() (string, error) {
 	switch runtime.GOOS {
 	case "linux", "darwin":
+		if userMode {
+			return userConfigPath("smartsentry-agent"), nil
+		}
 		return "/etc/smartsentry-agent", nil
 	case "windows":
 		// Sur Windows, utiliser ProgramData
@@ -66,6 +191,15 @@ func getConfigDirectory() (string, error) {
 	}
 }
 
+// getConfigPath retourne le chemin du fichier config.yaml de l'agent
+func getConfigPath() (string, error) {
+	configDir, err := getConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "config.yaml"), nil
+}
+
 // getDefaultConfigURL retourne l'URL de la configuration par défaut selon l'OS
 func getDefaultConfigURL() string {
 	switch runtime.GOOS {
@@ -81,7 +215,7 @@ func getDefaultConfigURL() string {
 
 // promptForGatewayURL demande à l'utilisateur l'adresse de son SmartSentry Gateway
 func promptForGatewayURL() (string, error) {
-	fmt.Println("\n🔧 Configuration du SmartSentry Gateway")
+	fmt.Println("\nConfiguration du SmartSentry Gateway")
 	fmt.Println("Entrez l'adresse de votre SmartSentry Gateway (ex: http://192.168.1.100:30080)")
 	fmt.Println("Cette adresse correspond à l'IP de votre cluster k3s avec le port NodePort du Gateway.")
 	fmt.Print("URL du Gateway : ")
@@ -98,23 +232,22 @@ func promptForGatewayURL() (string, error) {
 		return "", fmt.Errorf("l'URL du Gateway ne peut pas être vide")
 	}
 
-	// Ajouter http:// si pas de schéma
-	if !strings.HasPrefix(gatewayURL, "http://") && !strings.HasPrefix(gatewayURL, "https://") {
-		gatewayURL = "http://" + gatewayURL
-	}
-
-	fmt.Printf("✅ Gateway configuré : %s\n", gatewayURL)
+	gatewayURL = normalizeGatewayURL(gatewayURL)
+	slog.Info("Gateway configuré", "url", gatewayURL)
 	return gatewayURL, nil
 }
 
-// updateConfigWithGateway lit le fichier de config, remplace l'endpoint et le sauvegarde
-func updateConfigWithGateway(configPath, gatewayURL string) error {
-	// Lire le contenu du fichier
-	content, err := os.ReadFile(configPath)
-	if err != nil {
-		return fmt.Errorf("impossible de lire %s : %w", configPath, err)
+// normalizeGatewayURL ajoute http:// à une adresse de Gateway sans schéma
+func normalizeGatewayURL(gatewayURL string) string {
+	gatewayURL = strings.TrimSpace(gatewayURL)
+	if gatewayURL != "" && !strings.HasPrefix(gatewayURL, "http://") && !strings.HasPrefix(gatewayURL, "https://") {
+		gatewayURL = "http://" + gatewayURL
 	}
+	return gatewayURL
+}
 
+// renderConfigWithGateway remplace les placeholders du Gateway dans le modèle de configuration
+func renderConfigWithGateway(content []byte, gatewayURL string) []byte {
 	// Remplacer le placeholder par l'URL réelle
 	// Le placeholder dans les configs par défaut est : REMPLACE-PAR-IP-GATEWAY:30080
 	configStr := string(content)
@@ -132,13 +265,41 @@ func updateConfigWithGateway(configPath, gatewayURL string) error {
 		configStr = strings.ReplaceAll(configStr, old, new)
 	}
 
-	// Écrire le fichier mis à jour
-	err = os.WriteFile(configPath, []byte(configStr), 0644)
-	if err != nil {
-		return fmt.Errorf("impossible d'écrire %s : %w", configPath, err)
+	return []byte(configStr)
+}
+
+// readGatewayEndpoint extrait l'endpoint de l'exporter OTLP vers le Gateway d'une configuration
+func readGatewayEndpoint(content []byte) (string, error) {
+	endpoint, _, err := readGatewayExporter(content)
+	return endpoint, err
+}
+
+// readGatewayExporter extrait l'endpoint de l'exporter OTLP vers le Gateway et
+// les autorités de certification qui vérifient son certificat (tls.ca_file,
+// vide pour les autorités du système)
+func readGatewayExporter(content []byte) (string, string, error) {
+	var parsed struct {
+		Exporters map[string]struct {
+			Endpoint string `yaml:"endpoint"`
+			TLS      struct {
+				CAFile string `yaml:"ca_file"`
+			} `yaml:"tls"`
+		} `yaml:"exporters"`
+	}
+	if err := yaml.Unmarshal(content, &parsed); err != nil {
+		return "", "", fmt.Errorf("configuration YAML invalide : %w", err)
 	}
 
-	return nil
+	// Préférer l'exporter otlphttp, puis n'importe quel exporter OTLP
+	for _, prefix := range []string{"otlphttp", "otlp"} {
+		for id, exporter := range parsed.Exporters {
+			if componentType(id) == prefix && exporter.Endpoint != "" {
+				return exporter.Endpoint, exporter.TLS.CAFile, nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("aucun exporter OTLP avec endpoint dans la configuration")
 }
 
 // createSystemUser crée un utilisateur système dédié pour l'agent (Linux uniquement)
@@ -148,51 +309,66 @@ func createSystemUser() error {
 		return nil
 	}
 
-	fmt.Println("👤 Création de l'utilisateur système 'smartsentry'...")
+	slog.Info("Création de l'utilisateur système", "user", "smartsentry")
 
 	// Vérifier si l'utilisateur existe déjà
 	if userExists("smartsentry") {
-		fmt.Println("✅ L'utilisateur 'smartsentry' existe déjà")
+		slog.Info("Utilisateur système déjà présent", "user", "smartsentry")
 		return nil
 	}
 
-	// Créer l'utilisateur système avec un shell non-interactif
-	cmd := fmt.Sprintf("useradd --system --no-create-home --shell /usr/sbin/nologin smartsentry")
-	if err := runSystemCommand("bash", "-c", cmd); err != nil {
+	// Créer l'utilisateur système avec un shell non-interactif : useradd
+	// (shadow-utils) ou, sur Alpine/BusyBox qui n'ont ni useradd ni bash,
+	// addgroup/adduser
+	var err error
+	if _, lookErr := exec.LookPath("useradd"); lookErr == nil {
+		err = runSystemCommand("useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", "smartsentry")
+	} else {
+		err = runSystemCommand("addgroup", "-S", "smartsentry")
+		if err == nil {
+			err = runSystemCommand("adduser", "-S", "-D", "-H", "-s", "/sbin/nologin", "-G", "smartsentry", "smartsentry")
+		}
+	}
+	if err != nil {
 		return fmt.Errorf("impossible de créer l'utilisateur système : %w", err)
 	}
 
-	fmt.Println("✅ Utilisateur 'smartsentry' créé avec succès")
+	slog.Info("Utilisateur système créé", "user", "smartsentry")
 	return nil
 }
 
 // userExists vérifie si un utilisateur système existe
 func userExists(username string) bool {
-	cmd := fmt.Sprintf("id %s", username)
-	err := runSystemCommand("bash", "-c", cmd)
-	return err == nil // Si la commande réussit, l'utilisateur existe
+	_, err := user.Lookup(username)
+	return err == nil // Si la recherche réussit, l'utilisateur existe
 }
 
-// createLogDirectory crée le répertoire de logs avec les bonnes permissions
-func createLogDirectory() error {
-	var logDir string
-
+// platformLogDirectory retourne le répertoire de logs selon l'OS
+func platformLogDirectory() (string, error) {
 	switch runtime.GOOS {
 	case "linux":
-		logDir = "/var/log/smartsentry-agent"
+		return getLogDirectory(), nil
 	case "windows":
 		programData := os.Getenv("ProgramData")
 		if programData == "" {
-			return fmt.Errorf("variable ProgramData non définie")
+			return "", fmt.Errorf("variable ProgramData non définie")
 		}
-		logDir = filepath.Join(programData, "SmartSentry", "Agent", "Logs")
+		return filepath.Join(programData, "SmartSentry", "Agent", "Logs"), nil
 	case "darwin":
-		logDir = "/var/log/smartsentry-agent"
+		return "/var/log/smartsentry-agent", nil
 	default:
-		return fmt.Errorf("OS non supporté pour les logs : %s", runtime.GOOS)
+		return "", fmt.Errorf("OS non supporté pour les logs : %s", runtime.GOOS)
 	}
+}
 
-	fmt.Printf("📝 Création du répertoire de logs : %s\n", logDir)
+// createLogDirectory crée le répertoire de logs avec les bonnes permissions
+func createLogDirectory() error {
+	logDir, err := platformLogDirectory()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Création du répertoire de logs", "path", logDir)
 
 	// Créer le répertoire avec les bonnes permissions
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -200,25 +376,33 @@ func createLogDirectory() error {
 	}
 
 	// Sur Linux, changer le propriétaire vers l'utilisateur smartsentry
-	if runtime.GOOS == "linux" {
-		cmd := fmt.Sprintf("chown smartsentry:smartsentry %s", logDir)
-		if err := runSystemCommand("bash", "-c", cmd); err != nil {
-			fmt.Printf("⚠️  Attention : impossible de changer le propriétaire de %s : %v\n", logDir, err)
+	if runtime.GOOS == "linux" && !userMode {
+		if err := runSystemCommand("chown", "smartsentry:smartsentry", logDir); err != nil {
+			slog.Warn("Impossible de changer le propriétaire du répertoire de logs", "path", logDir, "error", err)
 		}
 	}
 
 	return nil
 }
 
-// runSystemCommand exécute une commande système et affiche l'erreur si échec
+// runSystemCommand exécute une commande système et affiche sa sortie si échec ;
+// commande, code de sortie et sortie sont toujours journalisés
 func runSystemCommand(name string, args ...string) error {
 	cmd := exec.Command(name, args...)
 	output, err := cmd.CombinedOutput()
+	logCommand(name, args, err, output)
 	if err != nil {
 		if len(output) > 0 {
-			fmt.Printf("Erreur commande '%s %s': %s\n", name, strings.Join(args, " "), string(output))
+			slog.Warn("Commande en échec", "command", name+" "+strings.Join(args, " "), "output", strings.TrimSpace(string(output)))
 		}
 		return fmt.Errorf("commande '%s %s' échouée : %w", name, strings.Join(args, " "), err)
 	}
 	return nil
 }
+
+// commandOutput exécute une commande et retourne sa sortie combinée, sans espaces superflus
+func commandOutput(name string, args ...string) (string, error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	logCommand(name, args, err, output)
+	return strings.TrimSpace(string(output)), err
+}
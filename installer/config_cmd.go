@@ -0,0 +1,463 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Clés réglables par config get/set (tags.<nom> en plus)
+var configKeys = []string{"gateway.url", "gateway.token", "collection.interval", "tags.<nom>"}
+
+// configState regroupe la configuration installée et le fichier d'environnement
+// pendant une modification par config set
+type configState struct {
+	configPath string
+	envPath    string
+
+	content []byte
+	doc     *yaml.Node
+	env     map[string]string
+
+	// Valeurs à écrire dans agent.env et indicateur de modification de config.yaml
+	envUpdates    map[string]string
+	configChanged bool
+}
+
+// runConfig exécute les sous-commandes config get et config set
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage : config get <clé> | config set <clé>=<valeur>... (clés : %s)", strings.Join(configKeys, ", "))
+	}
+
+	switch args[0] {
+	case "get":
+		return runConfigGet(args[1:])
+	case "set":
+		return runConfigSet(args[1:])
+	default:
+		return fmt.Errorf("sous-commande inconnue : %s (get ou set)", args[0])
+	}
+}
+
+// runConfigGet affiche la valeur d'une clé de configuration
+func runConfigGet(args []string) error {
+	fs := flag.NewFlagSet("config get", flag.ExitOnError)
+	reveal := fs.Bool("reveal", false, "afficher le jeton en clair")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage : config get <clé> (clés : %s)", strings.Join(configKeys, ", "))
+	}
+
+	state, err := loadConfigState()
+	if err != nil {
+		return err
+	}
+
+	value, err := state.get(fs.Arg(0), *reveal)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+// runConfigSet applique des modifications cle=valeur, valide le résultat avec
+// le collector puis recharge le service (redémarrage si agent.env change) ;
+// rien n'est écrit si la validation échoue
+func runConfigSet(args []string) error {
+	fs := flag.NewFlagSet("config set", flag.ExitOnError)
+	noRestart := fs.Bool("no-restart", false, "ne pas recharger ni redémarrer le service après modification")
+	readyTimeout := fs.Duration("ready-timeout", 60*time.Second, "délai d'attente de la disponibilité du collector après rechargement ou redémarrage")
+	journalLines := fs.Int("journal-lines", 30, "nombre de lignes de journal affichées en cas d'échec du redémarrage")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage : config set <clé>=<valeur>... (clés : %s)", strings.Join(configKeys, ", "))
+	}
+	if !hasRequiredPrivileges() {
+		return fmt.Errorf("privilèges administrateur requis pour modifier la configuration")
+	}
+
+	state, err := loadConfigState()
+	if err != nil {
+		return err
+	}
+
+	for _, assignment := range fs.Args() {
+		key, value, err := parseAssignment(assignment)
+		if err != nil {
+			return err
+		}
+		if err := state.set(key, value); err != nil {
+			return fmt.Errorf("%s : %w", key, err)
+		}
+	}
+
+	rendered, err := marshalConfigDocument(state.doc)
+	if err != nil {
+		return err
+	}
+	diff := unifiedDiff(state.configPath+" (actuelle)", state.configPath+" (nouvelle)", state.content, rendered)
+	state.configChanged = state.configChanged && diff != ""
+	for key, value := range state.envUpdates {
+		if current, ok := state.env[key]; ok && current == value {
+			delete(state.envUpdates, key)
+		}
+	}
+	if !state.configChanged && len(state.envUpdates) == 0 {
+		slog.Info("Aucune modification : la configuration est déjà à jour")
+		return nil
+	}
+
+	// Valider la configuration candidate avec l'environnement qu'aura le service
+	env := map[string]string{}
+	for key, value := range state.env {
+		env[key] = value
+	}
+	for key, value := range state.envUpdates {
+		env[key] = value
+	}
+	slog.Info("Validation de la nouvelle configuration")
+	if err := validateConfigCandidate(state.configPath, rendered, env); err != nil {
+		return fmt.Errorf("aucune modification appliquée : %w", err)
+	}
+	slog.Info("Configuration valide")
+
+	prepareConfigRollback()
+
+	if state.configChanged {
+		fmt.Println(diff)
+		backupPath, err := backupConfigWithTimestamp(state.configPath, state.content)
+		if err != nil {
+			return fmt.Errorf("impossible de sauvegarder la configuration : %w", err)
+		}
+		slog.Info("Configuration précédente sauvegardée", "path", backupPath)
+
+		if err := writeBytesAtomic(state.configPath, rendered, 0644); err != nil {
+			return fmt.Errorf("impossible d'écrire la configuration : %w", err)
+		}
+	}
+	if len(state.envUpdates) > 0 {
+		// L'ancienne version est conservée dans agent.env.bak
+		if err := updateEnvFile(state.envPath, state.envUpdates); err != nil {
+			return fmt.Errorf("impossible d'écrire le fichier d'environnement : %w", err)
+		}
+		slog.Info("Fichier d'environnement mis à jour", "path", state.envPath, "variables", strings.Join(sortedKeys(tagKeys(state.envUpdates)), ", "))
+	}
+	slog.Info("Configuration mise à jour", "path", state.configPath)
+
+	if *noRestart {
+		slog.Warn("Service non redémarré (--no-restart) : les modifications s'appliqueront au prochain démarrage")
+		updateInstallManifest()
+		return nil
+	}
+
+	// Le collector relit config.yaml sur SIGHUP, mais son environnement
+	// (agent.env) n'est chargé qu'au démarrage du service
+	if len(state.envUpdates) > 0 {
+		slog.Info("Fichier d'environnement modifié : redémarrage nécessaire", "path", state.envPath)
+		err = restartAgentService(*readyTimeout, *journalLines)
+	} else {
+		err = reloadAgentService(*readyTimeout, *journalLines)
+	}
+	// Après un éventuel retour arrière, le manifeste décrit la configuration en place
+	updateInstallManifest()
+	return err
+}
+
+// loadConfigState lit la configuration installée et le fichier d'environnement
+func loadConfigState() (*configState, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("impossible de lire %s (agent non installé ?) : %w", configPath, err)
+	}
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	envPath, err := getEnvFilePath()
+	if err != nil {
+		return nil, err
+	}
+	env, err := readEnvFile(envPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("impossible de lire %s : %w", envPath, err)
+	}
+
+	return &configState{
+		configPath: configPath,
+		envPath:    envPath,
+		content:    content,
+		doc:        doc,
+		env:        env,
+		envUpdates: map[string]string{},
+	}, nil
+}
+
+// parseAssignment découpe une affectation cle=valeur
+func parseAssignment(assignment string) (string, string, error) {
+	key, value, ok := strings.Cut(assignment, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("affectation invalide : %q (format attendu : clé=valeur)", assignment)
+	}
+	return key, value, nil
+}
+
+// get retourne la valeur effective d'une clé
+func (s *configState) get(key string, reveal bool) (string, error) {
+	root := yamlRoot(s.doc)
+
+	switch {
+	case key == "gateway.url":
+		endpoint, err := readGatewayEndpoint(s.content)
+		if err != nil {
+			return "", err
+		}
+		return expandEnvReferences(endpoint, s.env), nil
+
+	case key == "gateway.token":
+		token := strings.TrimPrefix(expandEnvReferences(s.authorizationHeader(), s.env), "Bearer ")
+		if token == "" || reveal {
+			return token, nil
+		}
+		return maskSecret(token), nil
+
+	case key == "collection.interval":
+		return yamlValue(yamlLookup(root, "receivers", "hostmetrics", "collection_interval")), nil
+
+	case key == "tags":
+		tags := readResourceTags(root)
+		var lines []string
+		for _, name := range sortedKeys(tagKeys(tags)) {
+			lines = append(lines, name+"="+tags[name])
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case strings.HasPrefix(key, "tags."):
+		value, ok := readResourceTags(root)[strings.TrimPrefix(key, "tags.")]
+		if !ok {
+			return "", fmt.Errorf("tag %s non défini", strings.TrimPrefix(key, "tags."))
+		}
+		return value, nil
+
+	default:
+		return "", fmt.Errorf("clé inconnue : %s (clés : %s)", key, strings.Join(configKeys, ", "))
+	}
+}
+
+// set modifie une clé ; les valeurs référencées via ${env:...} sont mises à
+// jour dans agent.env, les autres directement dans config.yaml
+func (s *configState) set(key, value string) error {
+	root := yamlRoot(s.doc)
+
+	switch {
+	case key == "gateway.url":
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("URL invalide : %q (ex: https://gateway.example.com:4318)", value)
+		}
+		endpoint, err := readGatewayEndpoint(s.content)
+		if err == nil && endpoint == envReference(ENV_GATEWAY_ENDPOINT) {
+			s.envUpdates[ENV_GATEWAY_ENDPOINT] = value
+			return nil
+		}
+		s.configChanged = true
+		return setGatewayExporter(root, value, "", false)
+
+	case key == "gateway.token":
+		if s.env != nil && value != "" {
+			// Installation avec agent.env : le jeton y est stocké et référencé
+			s.envUpdates[ENV_TOKEN] = value
+			if s.authorizationHeader() == "Bearer "+envReference(ENV_TOKEN) {
+				return nil
+			}
+			value = envReference(ENV_TOKEN)
+		}
+		s.configChanged = true
+		return setGatewayToken(root, value)
+
+	case key == "collection.interval":
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("durée invalide : %q (ex: 30s, 1m)", value)
+		}
+		hostmetrics := yamlLookup(root, "receivers", "hostmetrics")
+		if hostmetrics == nil || hostmetrics.Kind != yaml.MappingNode {
+			return fmt.Errorf("receiver hostmetrics absent de la configuration")
+		}
+		s.configChanged = true
+		yamlSet(hostmetrics, "collection_interval", yamlScalar(value))
+		return nil
+
+	case strings.HasPrefix(key, "tags."):
+		s.configChanged = true
+		return setResourceTag(root, strings.TrimPrefix(key, "tags."), value)
+
+	default:
+		return fmt.Errorf("clé inconnue (clés : %s)", strings.Join(configKeys, ", "))
+	}
+}
+
+// authorizationHeader retourne l'en-tête Authorization de l'exporter vers le Gateway
+func (s *configState) authorizationHeader() string {
+	exporters := yamlGet(yamlRoot(s.doc), "exporters")
+	if exporters == nil {
+		return ""
+	}
+	for i := 0; i+1 < len(exporters.Content); i += 2 {
+		if componentType(exporters.Content[i].Value) == "otlphttp" {
+			return yamlValue(yamlLookup(exporters.Content[i+1], "headers", "Authorization"))
+		}
+	}
+	return ""
+}
+
+// setGatewayToken fixe (ou retire si vide) l'en-tête d'authentification des
+// exporters vers le Gateway
+func setGatewayToken(root *yaml.Node, token string) error {
+	exporters := yamlGet(root, "exporters")
+	found := false
+	for i := 0; exporters != nil && i+1 < len(exporters.Content); i += 2 {
+		if componentType(exporters.Content[i].Value) != "otlphttp" {
+			continue
+		}
+		exporter := exporters.Content[i+1]
+		if token == "" {
+			yamlDelete(yamlGet(exporter, "headers"), "Authorization")
+		} else {
+			yamlSet(yamlEnsureMapping(exporter, "headers"), "Authorization", yamlScalar("Bearer "+token))
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("aucun exporter otlphttp vers le Gateway dans la configuration")
+	}
+	return nil
+}
+
+// maskSecret masque un secret en ne laissant visibles que ses 4 derniers caractères
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
+
+// validateConfigCandidate valide une configuration avant son installation :
+// elle est écrite dans un fichier temporaire du même répertoire que la
+// configuration (chemins relatifs identiques) puis soumise au collector
+func validateConfigCandidate(configPath string, content []byte, env map[string]string) error {
+	candidate, err := os.CreateTemp(filepath.Dir(configPath), ".config-candidate-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(candidate.Name())
+
+	if _, err := candidate.Write(content); err != nil {
+		candidate.Close()
+		return err
+	}
+	if err := candidate.Close(); err != nil {
+		return err
+	}
+
+	return validateConfigFile(candidate.Name(), env)
+}
+
+// validateConfigFile exécute "otelcol-contrib validate" (avec l'image en
+// conteneur) ; les références ${env:...} sont résolues depuis env comme le
+// fait systemd avec agent.env
+func validateConfigFile(configPath string, env map[string]string) error {
+	if mode, engine := installedRuntime(); mode == RUNTIME_CONTAINER {
+		return validateContainerConfig(engine, configPath, env)
+	}
+
+	binaryPath := getBinaryPath()
+	if _, err := os.Stat(binaryPath); err != nil {
+		return fmt.Errorf("%s absent : validation impossible", binaryPath)
+	}
+
+	cmd := exec.Command(binaryPath, "validate", "--config="+configPath)
+	cmd.Env = environWith(env)
+	out, err := cmd.CombinedOutput()
+	logCommand(binaryPath, cmd.Args[1:], err, out)
+	if err != nil {
+		return fmt.Errorf("configuration invalide :\n%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reloadAgentService applique une modification de config.yaml sans
+// redémarrer le service, pour ne pas perdre les lots en cours ; hors Linux,
+// le service est redémarré
+func reloadAgentService(readyTimeout time.Duration, journalLines int) error {
+	if runtime.GOOS != "linux" {
+		return restartAgentService(readyTimeout, journalLines)
+	}
+	if !isLinuxServiceActive() {
+		slog.Warn("Service inactif : les modifications s'appliqueront à son prochain démarrage", "service", SERVICE_NAME)
+		return nil
+	}
+
+	slog.Info("Rechargement de la configuration du service", "service", SERVICE_NAME)
+	// Le retour arrière redémarre complètement le service : un collector
+	// arrêté pendant le rechargement ne peut plus être rechargé
+	reloaded := false
+	err := restartWithRollback(func() error {
+		if reloaded {
+			return restartLinuxService(readyTimeout, journalLines)
+		}
+		reloaded = true
+		return reloadLinuxService(readyTimeout, journalLines)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Service rechargé avec la nouvelle configuration")
+	return nil
+}
+
+// restartAgentService redémarre le service pour appliquer la configuration ;
+// la configuration précédente est restaurée si le collector ne démarre pas
+func restartAgentService(readyTimeout time.Duration, journalLines int) error {
+	switch runtime.GOOS {
+	case "linux":
+		if !isLinuxServiceActive() {
+			slog.Warn("Service inactif : les modifications s'appliqueront à son prochain démarrage", "service", SERVICE_NAME)
+			return nil
+		}
+		slog.Info("Redémarrage du service", "service", SERVICE_NAME)
+		err := restartWithRollback(func() error {
+			return restartLinuxService(readyTimeout, journalLines)
+		})
+		if err != nil {
+			return err
+		}
+	case "windows":
+		if err := restartWithRollback(restartWindowsService); err != nil {
+			return err
+		}
+	default:
+		slog.Warn("Redémarrez manuellement l'agent pour appliquer les modifications")
+		return nil
+	}
+	slog.Info("Service redémarré avec la nouvelle configuration")
+	return nil
+}
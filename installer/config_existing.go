@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Politiques applicables à une configuration déjà installée (--config-policy)
+	CONFIG_POLICY_KEEP    = "keep"    // conserver la configuration existante
+	CONFIG_POLICY_REPLACE = "replace" // remplacer par la nouvelle configuration
+	CONFIG_POLICY_BACKUP  = "backup"  // sauvegarder explicitement puis remplacer
+	CONFIG_POLICY_MERGE   = "merge"   // compléter l'existante avec les nouvelles clés et les options explicites
+)
+
+// validConfigPolicy indique si la politique fournie en ligne de commande est reconnue
+func validConfigPolicy(policy string) bool {
+	switch policy {
+	case "", CONFIG_POLICY_KEEP, CONFIG_POLICY_REPLACE, CONFIG_POLICY_BACKUP, CONFIG_POLICY_MERGE:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveExistingConfig compare la configuration installée avec la nouvelle,
+// affiche le diff et applique la politique choisie (overrides : valeurs
+// passées explicitement, prioritaires en cas de fusion). Retourne le contenu à
+// écrire (inchangé s'il est identique à l'existant), ou nil si la
+// configuration existante doit être conservée.
+func resolveExistingConfig(configPath string, existing, rendered []byte, policy string, overrides renderOptions) ([]byte, error) {
+	diff := unifiedDiff(configPath+" (actuelle)", configPath+" (nouvelle)", existing, rendered)
+	if diff == "" {
+		slog.Info("La configuration existante est identique à la nouvelle", "path", configPath)
+		return rendered, nil
+	}
+
+	fmt.Println("\nUne configuration existe déjà. Différences avec la nouvelle configuration :")
+	fmt.Println(diff)
+
+	if policy == "" {
+		if !isInteractive() {
+			// Sans terminal, ne jamais écraser les modifications locales par défaut
+			slog.Info("Exécution non interactive sans --config-policy : configuration existante conservée", "path", configPath)
+			return nil, nil
+		}
+
+		var err error
+		policy, err = promptForConfigPolicy()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result []byte
+	switch policy {
+	case CONFIG_POLICY_KEEP:
+		return nil, nil
+	case CONFIG_POLICY_REPLACE, CONFIG_POLICY_BACKUP:
+		result = rendered
+	case CONFIG_POLICY_MERGE:
+		merged, err := mergeConfigs(existing, rendered, overrides)
+		if err != nil {
+			return nil, fmt.Errorf("impossible de fusionner les configurations : %w", err)
+		}
+		result = merged
+	default:
+		return nil, fmt.Errorf("politique de configuration inconnue : %s", policy)
+	}
+
+	// Toujours garder une copie horodatée avant de remplacer
+	backupPath, err := backupConfigWithTimestamp(configPath, existing)
+	if err != nil {
+		return nil, fmt.Errorf("impossible de sauvegarder la configuration existante : %w", err)
+	}
+	slog.Info("Configuration précédente sauvegardée", "path", backupPath)
+
+	return result, nil
+}
+
+// promptForConfigPolicy demande à l'utilisateur quoi faire de la configuration existante
+func promptForConfigPolicy() (string, error) {
+	for {
+		fmt.Print("Que faire ? [k]eep (conserver) / [r]eplace (remplacer) / [m]erge (fusionner) : ")
+
+		var choice string
+		if _, err := fmt.Scanln(&choice); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", fmt.Errorf("erreur lors de la lecture : %w", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(choice)) {
+		case "k", "keep":
+			return CONFIG_POLICY_KEEP, nil
+		case "r", "replace":
+			return CONFIG_POLICY_REPLACE, nil
+		case "m", "merge":
+			return CONFIG_POLICY_MERGE, nil
+		}
+		fmt.Println("Choix invalide")
+	}
+}
+
+// isInteractive indique si l'entrée standard est un terminal
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// backupConfigWithTimestamp enregistre une copie horodatée de la configuration
+func backupConfigWithTimestamp(configPath string, content []byte) (string, error) {
+	backupPath := fmt.Sprintf("%s.%s.bak", configPath, time.Now().Format("20060102-150405"))
+	// La configuration peut contenir des secrets : la sauvegarde reste privée
+	if err := writeBytesAtomic(backupPath, content, 0600); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// mergeConfigs complète la configuration existante avec les clés qui
+// n'existent que dans la nouvelle ; les valeurs existantes restent prioritaires
+// et les commentaires de l'existante sont préservés. Seules les options
+// passées explicitement à install l'emportent : endpoint, jeton et autorités
+// de certification du Gateway (--gateway, --token, --enroll) et tags (--tag),
+// ainsi que l'identifiant de l'agent. L'extension opamp d'une version
+// antérieure est retirée (voir removeOpAMPExtension).
+func mergeConfigs(existing, rendered []byte, overrides renderOptions) ([]byte, error) {
+	existingDoc, err := parseYAMLDocument(existing)
+	if err != nil {
+		return nil, fmt.Errorf("configuration existante : %w", err)
+	}
+	renderedDoc, err := parseYAMLDocument(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("nouvelle configuration : %w", err)
+	}
+
+	mergeYAMLNodes(existingDoc, renderedDoc)
+	root := yamlRoot(existingDoc)
+	if overrides.GatewayURL != "" {
+		if err := setGatewayExporter(root, gatewayEndpoint(overrides), gatewayToken(overrides), overrides.Token != ""); err != nil {
+			return nil, err
+		}
+	}
+	if overrides.CAFile != "" {
+		setGatewayTLS(root, overrides.CAFile)
+	}
+	if err := setResourceTags(root, overrides.Tags); err != nil {
+		return nil, err
+	}
+	// L'identifiant de l'agent fait foi (--reset-identity en particulier)
+	if overrides.InstanceUID != "" {
+		setAgentIdentity(root, overrides.InstanceUID, overrides.MachineID)
+	}
+	removeOpAMPExtension(root)
+	return marshalConfigDocument(existingDoc)
+}
+
+// mergeYAMLNodes ajoute récursivement dans dst les clés de src absentes de dst
+func mergeYAMLNodes(dst, src *yaml.Node) {
+	if dst.Kind == yaml.DocumentNode && src.Kind == yaml.DocumentNode {
+		if len(dst.Content) > 0 && len(src.Content) > 0 {
+			mergeYAMLNodes(dst.Content[0], src.Content[0])
+		}
+		return
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		found := false
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key.Value {
+				mergeYAMLNodes(dst.Content[j+1], value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst.Content = append(dst.Content, key, value)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// renderTestConfig génère une configuration à partir du modèle embarqué et
+// retourne son document et sa racine
+func renderTestConfig(t *testing.T, opts renderOptions) (*yaml.Node, *yaml.Node) {
+	t.Helper()
+	opts.Profile = PROFILE_STANDARD
+	opts.TargetOS = "linux"
+	rendered, err := renderConfig(packageDefaultTemplate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseTestConfig(t, rendered)
+}
+
+// parseTestConfig retourne le document et la racine d'une configuration
+func parseTestConfig(t *testing.T, content []byte) (*yaml.Node, *yaml.Node) {
+	t.Helper()
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc, yamlRoot(doc)
+}
+
+// gatewayExporter retourne le premier exporter otlphttp de la configuration
+func gatewayExporter(t *testing.T, root *yaml.Node) *yaml.Node {
+	t.Helper()
+	exporters := yamlGet(root, "exporters")
+	for i := 0; exporters != nil && i+1 < len(exporters.Content); i += 2 {
+		if componentType(exporters.Content[i].Value) == "otlphttp" {
+			return exporters.Content[i+1]
+		}
+	}
+	t.Fatal("aucun exporter otlphttp")
+	return nil
+}
+
+// resourceAttribute retourne la valeur d'un attribut du processor resource
+func resourceAttribute(root *yaml.Node, key string) string {
+	for _, attribute := range yamlLookup(root, "processors", "resource", "attributes").Content {
+		if yamlValue(yamlGet(attribute, "key")) == key {
+			return yamlValue(yamlGet(attribute, "value"))
+		}
+	}
+	return ""
+}
+
+func TestMergeConfigsOverrides(t *testing.T) {
+	// Configuration existante : ancien Gateway, ancienne autorité, TLS non
+	// vérifié ajouté à la main et attribut local
+	existingDoc, existingRoot := renderTestConfig(t, renderOptions{
+		GatewayURL:  "https://old-gateway:4318",
+		CAFile:      "/etc/smartsentry-agent/old-ca.pem",
+		Tags:        map[string]string{"env": "staging", "site": "paris"},
+		InstanceUID: "01928c4e-0000-7000-8000-000000000001",
+	})
+	yamlSet(yamlGet(gatewayExporter(t, existingRoot), "tls"), "insecure", yamlScalar("true"))
+	existing, err := marshalConfigDocument(existingDoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overrides := renderOptions{
+		GatewayURL:  "https://new-gateway:4318",
+		Token:       "agent-token",
+		CAFile:      "/etc/smartsentry-agent/gateway-ca.pem",
+		Tags:        map[string]string{"env": "prod"},
+		InstanceUID: "01928c4e-0000-7000-8000-000000000002",
+	}
+	opts := overrides
+	opts.Profile = PROFILE_STANDARD
+	opts.TargetOS = "linux"
+	rendered, err := renderConfig(packageDefaultTemplate, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := mergeConfigs(existing, rendered, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, root := parseTestConfig(t, merged)
+
+	exporter := gatewayExporter(t, root)
+	if got := yamlValue(yamlGet(exporter, "endpoint")); got != overrides.GatewayURL {
+		t.Errorf("endpoint %q, attendu %q", got, overrides.GatewayURL)
+	}
+	if got := yamlValue(yamlLookup(exporter, "headers", "Authorization")); got != "Bearer agent-token" {
+		t.Errorf("Authorization %q", got)
+	}
+	if got := yamlValue(yamlLookup(exporter, "tls", "ca_file")); got != overrides.CAFile {
+		t.Errorf("tls.ca_file %q, attendu %q", got, overrides.CAFile)
+	}
+	if yamlLookup(exporter, "tls", "insecure") != nil {
+		t.Error("tls.insecure conservé à côté de la nouvelle autorité")
+	}
+
+	for key, want := range map[string]string{
+		"env":                 "prod",
+		"site":                "paris",
+		"service.instance.id": overrides.InstanceUID,
+	} {
+		if got := resourceAttribute(root, key); got != want {
+			t.Errorf("attribut %s = %q, attendu %q", key, got, want)
+		}
+	}
+}
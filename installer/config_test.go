@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupTestOptions retourne des options d'installation utilisant le modèle
+// embarqué, sans interaction
+func setupTestOptions(t *testing.T, dir, policy string) installOptions {
+	t.Helper()
+	template := filepath.Join(dir, "linux-default-config.yaml")
+	if err := os.WriteFile(template, packageDefaultTemplate, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return installOptions{
+		ConfigPolicy:   policy,
+		ConfigTemplate: template,
+		GatewayURL:     "https://gateway.example:4318",
+		Profile:        PROFILE_STANDARD,
+		Tags:           tagFlag{},
+	}
+}
+
+// writeTestFile crée path et ses répertoires parents
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSetupConfigurationStoresAgentIDWithConfig(t *testing.T) {
+	dir := useTestDirs(t)
+	if err := setupConfiguration(setupTestOptions(t, dir, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	stateDir, _ := getStateDirectory()
+	id := strings.TrimSpace(readTestFile(t, filepath.Join(stateDir, AGENT_ID_FILE)))
+	configPath, _ := getConfigPath()
+	if id == "" || !strings.Contains(readTestFile(t, configPath), `"`+id+`"`) {
+		t.Errorf("agent-id %q absent de la configuration écrite", id)
+	}
+}
+
+func TestSetupConfigurationKeepCreatesNoAgentID(t *testing.T) {
+	dir := useTestDirs(t)
+	configPath, _ := getConfigPath()
+	writeTestFile(t, configPath, "receivers: {}\n")
+
+	if err := setupConfiguration(setupTestOptions(t, dir, CONFIG_POLICY_KEEP)); err != nil {
+		t.Fatal(err)
+	}
+
+	stateDir, _ := getStateDirectory()
+	if _, err := os.Stat(filepath.Join(stateDir, AGENT_ID_FILE)); !os.IsNotExist(err) {
+		t.Errorf("agent-id créé alors que la configuration conservée ne l'utilise pas : %v", err)
+	}
+}
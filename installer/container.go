@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Image amont du collector, à la même version que le binaire installé
+	OTEL_CONTAINER_IMAGE = "otel/opentelemetry-collector-contrib"
+
+	// Point de montage de la racine de l'hôte dans le conteneur (root_path de hostmetrics)
+	HOSTFS_ROOT = "/hostfs"
+
+	// Modes d'exécution du collector (--runtime)
+	RUNTIME_BINARY    = "binary"    // binaire otelcol-contrib installé sur l'hôte
+	RUNTIME_CONTAINER = "container" // image amont lancée par docker ou podman
+
+	// Moteurs de conteneurs reconnus (--container-engine)
+	CONTAINER_ENGINE_AUTO   = "auto"
+	CONTAINER_ENGINE_DOCKER = "docker"
+	CONTAINER_ENGINE_PODMAN = "podman"
+
+	// Fichier du répertoire d'état mémorisant le mode d'exécution et le moteur
+	RUNTIME_STATE_FILE = "runtime"
+)
+
+// collectorImage retourne l'image du collector à la version OTEL_VERSION
+func collectorImage() string {
+	return OTEL_CONTAINER_IMAGE + ":" + OTEL_VERSION
+}
+
+// adaptForContainer prépare la configuration pour un collector conteneurisé :
+// les métriques de l'hôte sont lues sous HOSTFS_ROOT, et journald est retiré
+// (l'image ne fournit pas journalctl)
+func adaptForContainer(root *yaml.Node) {
+	if hostmetrics := yamlLookup(root, "receivers", "hostmetrics"); hostmetrics != nil && hostmetrics.Kind == yaml.MappingNode {
+		yamlSet(hostmetrics, "root_path", yamlScalar(HOSTFS_ROOT))
+	}
+	if removeReceiver(root, "journald") {
+		slog.Warn("Receiver journald retiré en conteneur (journalctl absent de l'image)")
+	}
+}
+
+// validRuntime indique si un mode d'exécution est reconnu
+func validRuntime(mode string) bool {
+	return mode == RUNTIME_BINARY || mode == RUNTIME_CONTAINER
+}
+
+// validContainerEngine indique si un moteur de conteneurs est reconnu
+func validContainerEngine(engine string) bool {
+	switch engine {
+	case CONTAINER_ENGINE_AUTO, CONTAINER_ENGINE_DOCKER, CONTAINER_ENGINE_PODMAN:
+		return true
+	default:
+		return false
+	}
+}
+
+// findContainerEngine retourne le chemin du moteur demandé, ou du premier
+// moteur disponible (docker puis podman) pour "auto"
+func findContainerEngine(engine string) (string, error) {
+	candidates := []string{engine}
+	if engine == CONTAINER_ENGINE_AUTO {
+		candidates = []string{CONTAINER_ENGINE_DOCKER, CONTAINER_ENGINE_PODMAN}
+	}
+	for _, candidate := range candidates {
+		if path, err := exec.LookPath(candidate); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("aucun moteur de conteneurs trouvé (%s)", strings.Join(candidates, ", "))
+}
+
+// pullCollectorImage télécharge l'image du collector avec le moteur choisi
+func pullCollectorImage(engine string) error {
+	slog.Info("Téléchargement de l'image du collector", "image", collectorImage(), "engine", filepath.Base(engine))
+	return runSystemCommand(engine, "pull", collectorImage())
+}
+
+// saveRuntimeChoice mémorise le mode d'exécution (et le moteur en conteneur)
+// pour les commandes ultérieures (config set, verify, doctor...)
+func saveRuntimeChoice(mode, engine string) error {
+	stateDir, err := ensureStateDirectory()
+	if err != nil {
+		return err
+	}
+	content := mode + "\n"
+	if mode == RUNTIME_CONTAINER {
+		content += engine + "\n"
+	}
+	return writeBytesAtomic(filepath.Join(stateDir, RUNTIME_STATE_FILE), []byte(content), 0644)
+}
+
+// installedRuntime retourne le mode d'exécution choisi à l'installation et,
+// en conteneur, le chemin du moteur ; binary pour une installation antérieure
+func installedRuntime() (string, string) {
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return RUNTIME_BINARY, ""
+	}
+	content, err := os.ReadFile(filepath.Join(stateDir, RUNTIME_STATE_FILE))
+	if err != nil {
+		return RUNTIME_BINARY, ""
+	}
+	lines := strings.Fields(string(content))
+	if len(lines) == 2 && lines[0] == RUNTIME_CONTAINER {
+		return RUNTIME_CONTAINER, lines[1]
+	}
+	return RUNTIME_BINARY, ""
+}
+
+// containerConfigMountArgs monte le répertoire de configuration en lecture seule
+func containerConfigMountArgs(configPath string) []string {
+	configDir := filepath.Dir(configPath)
+	return []string{"-v", configDir + ":" + configDir + ":ro"}
+}
+
+// containerHostMountArgs monte en lecture seule la racine de l'hôte sous
+// HOSTFS_ROOT, avec /proc et /sys (sous-montages non inclus)
+func containerHostMountArgs() []string {
+	return []string{
+		"-v", "/:" + HOSTFS_ROOT + ":ro",
+		"-v", "/proc:" + HOSTFS_ROOT + "/proc:ro",
+		"-v", "/sys:" + HOSTFS_ROOT + "/sys:ro",
+	}
+}
+
+// containerEnvArgs transmet au conteneur l'endpoint et le jeton du Gateway
+// par leur nom : les valeurs restent dans
+// l'environnement du processus (EnvironmentFile de l'unité) et n'apparaissent
+// pas sur la ligne de commande ; une variable absente n'est pas transmise
+func containerEnvArgs() []string {
+	return []string{"-e", ENV_GATEWAY_ENDPOINT, "-e", ENV_TOKEN}
+}
+
+// containerRunArgs construit les arguments de "<moteur> run" lançant le
+// collector au premier plan, supervisé par l'unité systemd
+func containerRunArgs(configPath string, config []byte, asRoot bool) ([]string, error) {
+	args := []string{"run", "--rm", "--name", SERVICE_NAME,
+		// Réseau de l'hôte : host.name, métriques réseau et health_check en local
+		"--network", "host",
+		"--read-only", "--security-opt", "no-new-privileges"}
+	if asRoot {
+		args = append(args, "--user", "0:0")
+	}
+	args = append(args, containerConfigMountArgs(configPath)...)
+	args = append(args, containerHostMountArgs()...)
+
+	doc, err := parseYAMLDocument(config)
+	if err != nil {
+		return nil, err
+	}
+	receivers := yamlGet(yamlRoot(doc), "receivers")
+	for i := 0; receivers != nil && i < len(receivers.Content); i += 2 {
+		if componentType(receivers.Content[i].Value) == "filelog" {
+			args = append(args, "-v", "/var/log:/var/log:ro")
+			break
+		}
+	}
+
+	args = append(args, containerEnvArgs()...)
+	return append(args, collectorImage(), "--config="+configPath), nil
+}
+
+// validateContainerConfig valide une configuration avec l'image du collector
+func validateContainerConfig(engine, configPath string, env map[string]string) error {
+	args := append([]string{"run", "--rm", "--network", "none"}, containerConfigMountArgs(configPath)...)
+	args = append(args, containerEnvArgs()...)
+	args = append(args, collectorImage(), "validate", "--config="+configPath)
+
+	cmd := exec.Command(engine, args...)
+	cmd.Env = environWith(env)
+	out, err := cmd.CombinedOutput()
+	logCommand(engine, args, err, out)
+	if err != nil {
+		return fmt.Errorf("configuration invalide :\n%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// collectorFailurePatterns associe des messages typiques du collector à la
+// cause probable d'un échec de démarrage, par ordre de priorité
+var collectorFailurePatterns = []struct {
+	pattern *regexp.Regexp
+	cause   string
+}{
+	{regexp.MustCompile(`(?i)has invalid keys|unknown type|cannot unmarshal|error decoding|failed to get config|invalid configuration`), "clé ou valeur invalide dans la configuration"},
+	{regexp.MustCompile(`(?i)address already in use`), "port déjà utilisé par un autre processus"},
+	{regexp.MustCompile(`(?i)permission denied|operation not permitted`), "permission refusée pour l'utilisateur du service"},
+	{regexp.MustCompile(`(?i)no such file or directory`), "fichier ou répertoire introuvable"},
+	{regexp.MustCompile(`(?i)connection refused|no such host|i/o timeout|context deadline exceeded`), "Gateway injoignable"},
+}
+
+// logDiagnosis est le résultat de l'analyse des lignes de journal du collector
+type logDiagnosis struct {
+	// Causes probables, dans l'ordre de priorité et sans doublon
+	Causes []string
+	// Index des lignes correspondant à une cause, et la cause associée
+	Matches map[int]string
+}
+
+// diagnoseCollectorLogs recherche dans les lignes de journal la cause probable d'un échec
+func diagnoseCollectorLogs(lines []string) logDiagnosis {
+	diagnosis := logDiagnosis{Matches: map[int]string{}}
+	found := map[string]bool{}
+
+	for _, candidate := range collectorFailurePatterns {
+		for i, line := range lines {
+			if _, already := diagnosis.Matches[i]; already || !candidate.pattern.MatchString(line) {
+				continue
+			}
+			diagnosis.Matches[i] = candidate.cause
+			if !found[candidate.cause] {
+				found[candidate.cause] = true
+				diagnosis.Causes = append(diagnosis.Causes, candidate.cause)
+			}
+		}
+	}
+
+	return diagnosis
+}
+
+// logJournalExcerpt journalise un extrait de journal en signalant les lignes
+// qui expliquent l'échec, suivi des causes probables
+func logJournalExcerpt(journal string) {
+	lines := strings.Split(strings.TrimSpace(journal), "\n")
+	diagnosis := diagnoseCollectorLogs(lines)
+
+	slog.Info("Dernières lignes du journal", "lines", len(lines))
+	for i, line := range lines {
+		if cause, highlighted := diagnosis.Matches[i]; highlighted {
+			slog.Error("Journal", "line", line, "cause", cause)
+		} else {
+			slog.Info("Journal", "line", line)
+		}
+	}
+
+	if len(diagnosis.Causes) == 0 {
+		slog.Warn("Cause non identifiée automatiquement, consultez le journal complet")
+		return
+	}
+	for _, cause := range diagnosis.Causes {
+		slog.Error("Cause probable", "cause", cause)
+	}
+}
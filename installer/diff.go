@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DIFF_CONTEXT_LINES est le nombre de lignes de contexte autour de chaque modification
+const DIFF_CONTEXT_LINES = 3
+
+// diffOp représente une ligne du diff : ' ' inchangée, '-' supprimée, '+' ajoutée
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff retourne le diff unifié entre deux contenus texte, ou une chaîne
+// vide s'ils sont identiques
+func unifiedDiff(oldName, newName string, oldContent, newContent []byte) string {
+	oldLines := splitLines(string(oldContent))
+	newLines := splitLines(string(newContent))
+	ops := diffLines(oldLines, newLines)
+
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Regrouper les modifications proches en blocs (hunks) avec leur contexte
+	for start := 0; start < len(ops); {
+		// Trouver la prochaine modification
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// Étendre le bloc tant que deux modifications sont séparées par moins
+		// de 2*contexte lignes inchangées
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*DIFF_CONTEXT_LINES {
+				break
+			}
+		}
+
+		hunkStart := max(first-DIFF_CONTEXT_LINES, start)
+		hunkEnd := min(last+DIFF_CONTEXT_LINES+1, len(ops))
+
+		// Numéros de ligne de départ dans chaque fichier
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.line)
+		}
+
+		start = hunkEnd
+	}
+
+	return out.String()
+}
+
+// splitLines découpe un texte en lignes sans la fin de ligne finale
+func splitLines(content string) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+	if content == "" {
+		return nil
+	}
+	return strings.Split(content, "\n")
+}
+
+// diffLines calcule la suite d'opérations transformant a en b à partir de la
+// plus longue sous-séquence commune (suffisant pour des fichiers de configuration)
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] = longueur de la plus longue sous-séquence commune de a[i:] et b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
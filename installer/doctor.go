@@ -0,0 +1,537 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Statuts possibles d'une vérification du diagnostic
+	CHECK_PASS = "pass"
+	CHECK_WARN = "warn"
+	CHECK_FAIL = "fail"
+
+	// Espace disque minimal : le binaire installé, et l'archive + le binaire extrait dans /tmp
+	MIN_FREE_BINARY_DIR = 300 << 20
+	MIN_FREE_TEMP_DIR   = 500 << 20
+
+	// Version minimale de systemd supportant les directives du service
+	MIN_SYSTEMD_VERSION = 235
+
+	// Décalage d'horloge toléré avec le Gateway avant avertissement, puis échec
+	MAX_CLOCK_SKEW_WARN = 5 * time.Second
+	MAX_CLOCK_SKEW_FAIL = 5 * time.Minute
+
+	// Nombre de lignes de journal jointes au diagnostic
+	DOCTOR_JOURNAL_LINES = 20
+
+	// Score d'exposition systemd-analyze au-delà duquel le service est jugé
+	// insuffisamment isolé (0 = très isolé, 10 = aucune isolation)
+	DEFAULT_MAX_EXPOSURE = 5.0
+)
+
+// exposureLevelPattern extrait le score de la dernière ligne de systemd-analyze security :
+// "→ Overall exposure level for smartsentry-agent.service: 4.2 OK 🙂"
+var exposureLevelPattern = regexp.MustCompile(`Overall exposure level for \S+: ([0-9.]+) (\S+)`)
+
+// doctorEndpoints liste les adresses locales sur lesquelles le collector
+// écoute (télémétrie interne et extension health_check)
+var doctorEndpoints = []string{TELEMETRY_ENDPOINT, HEALTH_CHECK_ENDPOINT}
+
+// doctorCheck est le résultat d'une vérification du diagnostic
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// doctorReport est le rapport complet, sérialisable pour les tickets de support
+type doctorReport struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	OS          string        `json:"os"`
+	Arch        string        `json:"arch"`
+	Hostname    string        `json:"hostname"`
+	OTelVersion string        `json:"otel_version"`
+	Checks      []doctorCheck `json:"checks"`
+}
+
+// add enregistre le résultat d'une vérification
+func (r *doctorReport) add(name, status, detail string) {
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Status: status, Detail: detail})
+}
+
+// count retourne le nombre de vérifications ayant un statut donné
+func (r *doctorReport) count(status string) int {
+	n := 0
+	for _, check := range r.Checks {
+		if check.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// runDoctor implémente la commande doctor : vérifications avant installation
+// (prérequis) et après installation (configuration, service, journal)
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "produire le rapport au format JSON")
+	gatewayURL := fs.String("gateway", "", "URL du Gateway à tester (par défaut : celle de la configuration installée)")
+	maxExposure := fs.Float64("max-exposure", DEFAULT_MAX_EXPOSURE, "score d'exposition systemd-analyze security maximal accepté")
+	fs.Parse(args)
+
+	hostname, _ := os.Hostname()
+	report := &doctorReport{
+		GeneratedAt: time.Now().UTC(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Hostname:    hostname,
+		OTelVersion: OTEL_VERSION,
+	}
+
+	checkPrivileges(report)
+	serviceActive := false
+	serviceManager := ""
+	if runtime.GOOS == "linux" {
+		serviceManager = installedServiceManagerName()
+		if serviceManager == SERVICE_MANAGER_SYSTEMD {
+			checkSystemd(report)
+		} else {
+			// Les vérifications de l'unité et du sandboxing ne concernent que systemd
+			report.add("Système d'init", CHECK_PASS, serviceManager+" (vérifications systemd ignorées)")
+		}
+		serviceActive = isLinuxServiceActive()
+	}
+	checkDiskSpace(report)
+	checkCollectorProcesses(report, serviceActive)
+	checkPorts(report, serviceActive)
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		report.add("Configuration", CHECK_FAIL, err.Error())
+	} else {
+		checkConfiguration(report, configPath)
+		checkGateway(report, configPath, *gatewayURL)
+	}
+	if supervisorConfigPath, err := getSupervisorConfigPath(); err == nil {
+		checkOpAMP(report, supervisorConfigPath)
+	}
+
+	if serviceManager == SERVICE_MANAGER_SYSTEMD {
+		checkServiceUnit(report)
+		checkServiceExposure(report, *maxExposure)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printDoctorReport(report)
+	}
+
+	if failed := report.count(CHECK_FAIL); failed > 0 {
+		return fmt.Errorf("%d vérification(s) en échec", failed)
+	}
+	return nil
+}
+
+// printDoctorReport affiche le rapport sous forme lisible sur stdout : c'est
+// le résultat de la commande, pas un message journalisé (voir loggingOptions)
+func printDoctorReport(report *doctorReport) {
+	fmt.Println("🩺 Diagnostic SmartSentry Agent")
+	fmt.Printf("Hôte : %s (%s/%s), Collector : %s\n\n", report.Hostname, report.OS, report.Arch, report.OTelVersion)
+
+	for _, check := range report.Checks {
+		icon := "✅"
+		switch check.Status {
+		case CHECK_WARN:
+			icon = "⚠️ "
+		case CHECK_FAIL:
+			icon = "❌"
+		}
+
+		lines := strings.Split(check.Detail, "\n")
+		fmt.Printf("%s %-4s  %-28s %s\n", icon, strings.ToUpper(check.Status), check.Name, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Printf("      │ %s\n", line)
+		}
+	}
+
+	fmt.Printf("\nRésumé : %d pass, %d warn, %d fail\n",
+		report.count(CHECK_PASS), report.count(CHECK_WARN), report.count(CHECK_FAIL))
+}
+
+// checkPrivileges vérifie l'exécution en root/administrateur
+func checkPrivileges(report *doctorReport) {
+	if hasAdminPrivileges() {
+		report.add("Privilèges administrateur", CHECK_PASS, "exécution avec les privilèges requis")
+		return
+	}
+	if userMode {
+		report.add("Privilèges administrateur", CHECK_PASS, "installation utilisateur (--user-mode) : privilèges non requis")
+		return
+	}
+	report.add("Privilèges administrateur", CHECK_FAIL, "l'installation nécessite sudo (Linux/macOS) ou un compte Administrateur (Windows)")
+}
+
+// checkSystemd vérifie la présence de systemd et sa version
+func checkSystemd(report *doctorReport) {
+	// /run/systemd/system n'existe que si systemd est le gestionnaire d'init actif
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		report.add("systemd", CHECK_FAIL, "systemd n'est pas le système d'init actif")
+		return
+	}
+
+	output, err := commandOutput("systemctl", "--version")
+	if err != nil {
+		report.add("systemd", CHECK_FAIL, fmt.Sprintf("systemctl indisponible : %v", err))
+		return
+	}
+
+	// Première ligne : "systemd 252 (252.22-1~deb12u1)"
+	firstLine := strings.SplitN(output, "\n", 2)[0]
+	fields := strings.Fields(firstLine)
+	if len(fields) < 2 {
+		report.add("systemd", CHECK_WARN, "version non reconnue : "+firstLine)
+		return
+	}
+	version, err := strconv.Atoi(fields[1])
+	if err != nil {
+		report.add("systemd", CHECK_WARN, "version non reconnue : "+firstLine)
+		return
+	}
+	if version < MIN_SYSTEMD_VERSION {
+		report.add("systemd", CHECK_WARN, fmt.Sprintf("version %d inférieure à la version recommandée %d", version, MIN_SYSTEMD_VERSION))
+		return
+	}
+	report.add("systemd", CHECK_PASS, fmt.Sprintf("version %d", version))
+}
+
+// checkDiskSpace vérifie l'espace libre pour le binaire et les fichiers temporaires
+func checkDiskSpace(report *doctorReport) {
+	targets := []struct {
+		path string
+		min  uint64
+	}{
+		{filepath.Dir(getBinaryPath()), MIN_FREE_BINARY_DIR},
+		{os.TempDir(), MIN_FREE_TEMP_DIR},
+	}
+
+	for _, target := range targets {
+		name := "Espace disque " + target.path
+
+		// Le répertoire cible peut ne pas encore exister : remonter au parent existant
+		path := target.path
+		for {
+			if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+				break
+			}
+			path = filepath.Dir(path)
+		}
+
+		free, err := diskFreeBytes(path)
+		if err != nil {
+			report.add(name, CHECK_WARN, fmt.Sprintf("espace libre non mesurable : %v", err))
+			continue
+		}
+		detail := fmt.Sprintf("%d Mo libres (minimum %d Mo)", free>>20, target.min>>20)
+		if free < target.min {
+			report.add(name, CHECK_FAIL, detail)
+		} else {
+			report.add(name, CHECK_PASS, detail)
+		}
+	}
+}
+
+// checkCollectorProcesses détecte des processus otelcol déjà en cours d'exécution
+func checkCollectorProcesses(report *doctorReport, serviceActive bool) {
+	pids, ok := findProcessesByPrefix("otelcol")
+	if !ok {
+		report.add("Processus otelcol", CHECK_PASS, "liste des processus non disponible sur ce système")
+		return
+	}
+	if len(pids) == 0 {
+		report.add("Processus otelcol", CHECK_PASS, "aucun collector en cours d'exécution")
+		return
+	}
+
+	detail := fmt.Sprintf("PID %s", strings.Join(pids, ", "))
+	if serviceActive && len(pids) == 1 {
+		report.add("Processus otelcol", CHECK_PASS, detail+" (service "+SERVICE_NAME+")")
+		return
+	}
+	report.add("Processus otelcol", CHECK_WARN, detail+" : un autre collector peut entrer en conflit avec l'agent")
+}
+
+// findProcessesByPrefix liste les PID dont le nom de commande commence par prefix (via /proc)
+func findProcessesByPrefix(prefix string) ([]string, bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, false
+	}
+
+	var pids []string
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(comm)), prefix) {
+			pids = append(pids, entry.Name())
+		}
+	}
+	return pids, true
+}
+
+// checkPorts vérifie que les adresses d'écoute du collector sont libres, en
+// se liant à l'adresse configurée plutôt qu'à toutes les interfaces
+func checkPorts(report *doctorReport, serviceActive bool) {
+	for _, endpoint := range doctorEndpoints {
+		_, port, _ := net.SplitHostPort(endpoint)
+		name := "Port " + port
+
+		listener, err := net.Listen("tcp", endpoint)
+		if err == nil {
+			listener.Close()
+			report.add(name, CHECK_PASS, "libre")
+			continue
+		}
+
+		if serviceActive {
+			report.add(name, CHECK_PASS, "utilisé (probablement par le service "+SERVICE_NAME+")")
+		} else {
+			report.add(name, CHECK_WARN, fmt.Sprintf("déjà utilisé par un autre processus : %v", err))
+		}
+	}
+}
+
+// checkConfiguration valide la configuration installée avec le binaire du collector
+func checkConfiguration(report *doctorReport, configPath string) {
+	if _, err := os.Stat(configPath); err != nil {
+		report.add("Configuration", CHECK_WARN, fmt.Sprintf("%s absente (agent non installé ?)", configPath))
+		return
+	}
+
+	// En conteneur, la validation utilise l'image du collector
+	if mode, _ := installedRuntime(); mode != RUNTIME_CONTAINER {
+		if _, err := os.Stat(getBinaryPath()); err != nil {
+			report.add("Configuration", CHECK_WARN, fmt.Sprintf("%s absent : validation impossible", getBinaryPath()))
+			return
+		}
+	}
+
+	// Les références ${env:...} sont résolues depuis agent.env comme le fait systemd
+	if err := validateConfigFile(configPath, loadAgentEnv()); err != nil {
+		report.add("Configuration", CHECK_FAIL, err.Error())
+		return
+	}
+	report.add("Configuration", CHECK_PASS, configPath+" valide")
+}
+
+// checkGateway vérifie la résolution DNS du Gateway et le décalage d'horloge
+// par rapport à l'en-tête Date de sa réponse. Le certificat du Gateway est
+// vérifié avec les autorités de l'exporter (tls.ca_file), comme le collector.
+func checkGateway(report *doctorReport, configPath, gatewayURL string) {
+	configuredURL, caFile := "", ""
+	if content, err := os.ReadFile(configPath); err == nil {
+		endpoint, ca, err := readGatewayExporter(content)
+		if err != nil && gatewayURL == "" {
+			report.add("Gateway", CHECK_WARN, err.Error())
+			return
+		}
+		configuredURL = expandEnvReferences(endpoint, loadAgentEnv())
+		caFile = expandEnvReferences(ca, loadAgentEnv())
+	} else if gatewayURL == "" {
+		report.add("Gateway", CHECK_WARN, "aucune configuration installée et --gateway non fourni")
+		return
+	}
+	if gatewayURL == "" {
+		gatewayURL = configuredURL
+	}
+
+	parsed, err := url.Parse(gatewayURL)
+	if err != nil || parsed.Hostname() == "" {
+		report.add("Gateway DNS", CHECK_FAIL, fmt.Sprintf("URL du Gateway invalide : %s", gatewayURL))
+		return
+	}
+
+	host := parsed.Hostname()
+	if net.ParseIP(host) != nil {
+		report.add("Gateway DNS", CHECK_PASS, host+" est une adresse IP")
+	} else if addrs, err := net.LookupHost(host); err != nil {
+		report.add("Gateway DNS", CHECK_FAIL, fmt.Sprintf("résolution de %s impossible : %v", host, err))
+		return
+	} else {
+		report.add("Gateway DNS", CHECK_PASS, fmt.Sprintf("%s -> %s", host, strings.Join(addrs, ", ")))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		rootCAs, _, err := loadCAFile(caFile)
+		if err != nil {
+			report.add("Horloge / Gateway", CHECK_FAIL, fmt.Sprintf("autorités du Gateway inutilisables : %v", err))
+			return
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	resp, err := client.Get(gatewayURL)
+	if err != nil {
+		report.add("Horloge / Gateway", CHECK_FAIL, fmt.Sprintf("Gateway injoignable : %v", err))
+		return
+	}
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		report.add("Horloge / Gateway", CHECK_WARN, "le Gateway ne renvoie pas d'en-tête Date exploitable")
+		return
+	}
+
+	skew := time.Since(date).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	detail := fmt.Sprintf("décalage de %s avec le Gateway (HTTP %d)", skew, resp.StatusCode)
+	switch {
+	case skew > MAX_CLOCK_SKEW_FAIL:
+		report.add("Horloge / Gateway", CHECK_FAIL, detail+" : vérifier NTP")
+	case skew > MAX_CLOCK_SKEW_WARN:
+		report.add("Horloge / Gateway", CHECK_WARN, detail)
+	default:
+		report.add("Horloge / Gateway", CHECK_PASS, detail)
+	}
+}
+
+// checkOpAMP ouvre une session auprès du serveur OpAMP de l'opamp-supervisor,
+// avec le jeton et les autorités de certification qu'il utilise (aucune
+// vérification si l'agent n'est pas supervisé)
+func checkOpAMP(report *doctorReport, supervisorConfigPath string) {
+	config, err := readSupervisorConfig(supervisorConfigPath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		report.add("OpAMP", CHECK_FAIL, err.Error())
+		return
+	}
+
+	endpoint := config.Server.Endpoint
+	if err := validateOpAMPEndpoint(endpoint); err != nil {
+		report.add("OpAMP", CHECK_FAIL, err.Error())
+		return
+	}
+
+	// Jeton et autorités référencés depuis agent.env, comme pour le service
+	env := loadAgentEnv()
+	authorization := expandEnvReferences(config.Server.Headers["Authorization"], env)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Server.TLS != nil && config.Server.TLS.CAFile != "" {
+		rootCAs, _, err := loadCAFile(expandEnvReferences(config.Server.TLS.CAFile, env))
+		if err != nil {
+			report.add("OpAMP", CHECK_FAIL, fmt.Sprintf("autorités de certification du serveur OpAMP inutilisables : %v", err))
+			return
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+
+	if err := probeOpAMP(client, endpoint, authorization, opampInstanceUID()); err != nil {
+		report.add("OpAMP", CHECK_FAIL, fmt.Sprintf("serveur %s : %v", endpoint, err))
+		return
+	}
+	credentials := "sans jeton"
+	if authorization != "" {
+		credentials = "jeton accepté"
+	}
+	report.add("OpAMP", CHECK_PASS, fmt.Sprintf("serveur %s joignable, %s : %s", endpoint, credentials, describeOpAMP()))
+}
+
+// checkServiceUnit rapporte l'état de l'unité systemd et les dernières lignes du journal
+func checkServiceUnit(report *doctorReport) {
+	if _, err := os.Stat(systemdUnitPath()); err != nil {
+		report.add("Service "+SERVICE_NAME, CHECK_WARN, "unité non installée")
+		return
+	}
+
+	state, _ := commandOutput("systemctl", systemctlArgs("is-active", SERVICE_NAME)...)
+	enabled, _ := commandOutput("systemctl", systemctlArgs("is-enabled", SERVICE_NAME)...)
+
+	detail := fmt.Sprintf("état %s, démarrage automatique %s", state, enabled)
+	if state == "active" {
+		report.add("Service "+SERVICE_NAME, CHECK_PASS, detail)
+	} else {
+		report.add("Service "+SERVICE_NAME, CHECK_FAIL, detail)
+	}
+
+	journal, err := commandOutput("journalctl", append(journalctlUnitArgs(), "-n", strconv.Itoa(DOCTOR_JOURNAL_LINES), "--no-pager", "-o", "short-iso")...)
+	if err != nil || journal == "" {
+		report.add("Journal", CHECK_WARN, "journal indisponible")
+		return
+	}
+
+	lines := strings.Split(journal, "\n")
+	report.add("Journal", CHECK_PASS, fmt.Sprintf("%d dernières lignes\n%s", len(lines), journal))
+}
+
+// checkServiceExposure évalue le sandboxing de l'unité avec systemd-analyze security
+func checkServiceExposure(report *doctorReport, maxExposure float64) {
+	if _, err := os.Stat(systemdUnitPath()); err != nil {
+		return
+	}
+
+	output, err := commandOutput("systemd-analyze", systemctlArgs("security", SERVICE_NAME, "--no-pager")...)
+	match := exposureLevelPattern.FindStringSubmatch(output)
+	if match == nil {
+		detail := "score d'exposition indisponible (systemd-analyze security nécessite systemd 240+)"
+		if err != nil {
+			detail += fmt.Sprintf(" : %v", err)
+		}
+		report.add("Isolation systemd", CHECK_WARN, detail)
+		return
+	}
+
+	score, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		report.add("Isolation systemd", CHECK_WARN, "score d'exposition illisible : "+match[1])
+		return
+	}
+
+	detail := fmt.Sprintf("score d'exposition %.1f %s (seuil %.1f)", score, match[2], maxExposure)
+	if score > maxExposure {
+		report.add("Isolation systemd", CHECK_WARN, detail+" : réinstaller avec --hardened")
+		return
+	}
+	report.add("Isolation systemd", CHECK_PASS, detail)
+}
+
+// loadAgentEnv lit agent.env s'il existe (valeurs vides sinon)
+func loadAgentEnv() map[string]string {
+	envPath, err := getEnvFilePath()
+	if err != nil {
+		return nil
+	}
+	values, err := readEnvFile(envPath)
+	if err != nil {
+		return nil
+	}
+	return values
+}
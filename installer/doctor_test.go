@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// findCheck retourne le résultat de la vérification nommée
+func findCheck(t *testing.T, report *doctorReport, name string) doctorCheck {
+	t.Helper()
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("vérification %q absente : %+v", name, report.Checks)
+	return doctorCheck{}
+}
+
+func TestCheckGatewayUsesConfiguredCA(t *testing.T) {
+	dir := useTestDirs(t)
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "gateway-ca.pem")
+	if err := os.WriteFile(caFile, []byte(serverCertificatePEM(server)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tls    string
+		status string
+	}{
+		{"autorités de l'enrôlement", "    tls:\n      ca_file: " + caFile + "\n", CHECK_PASS},
+		{"autorités du système", "", CHECK_FAIL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			config := "exporters:\n  otlphttp:\n    endpoint: " + server.URL + "\n" + tt.tls
+			if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			report := &doctorReport{}
+			checkGateway(report, configPath, "")
+			if check := findCheck(t, report, "Horloge / Gateway"); check.Status != tt.status {
+				t.Errorf("statut %s (%s), attendu %s", check.Status, check.Detail, tt.status)
+			}
+		})
+	}
+}
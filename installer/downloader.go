@@ -6,57 +6,100 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 )
 
+// MAX_BINARY_SIZE borne la taille décompressée acceptée pour le binaire du
+// collector afin de se protéger des archives piégées (zip/gzip bombs)
+const MAX_BINARY_SIZE = 512 << 20
+
 // downloadOTelCollector télécharge et installe le binaire OpenTelemetry Collector
 // selon l'OS et l'architecture détectés
 func downloadOTelCollector() error {
+	// Le binaire livré par le paquet .deb/.rpm appartient au gestionnaire de
+	// paquets : l'écraser (avec sa copie .bak) ferait échouer dpkg -V / rpm -V
+	// et entrerait en conflit avec la prochaine mise à jour du paquet
+	if packagedCollector() {
+		return fmt.Errorf("%s est fourni par le paquet %s : mettez-le à jour ou réinstallez-le avec le gestionnaire de paquets (apt, dnf)", PACKAGE_COLLECTOR_PATH, PACKAGE_NAME)
+	}
+
+	// Répertoire de travail privé (créé en 0700) plutôt qu'un chemin fixe dans /tmp
+	workDir, err := os.MkdirTemp("", "smartsentry-installer-")
+	if err != nil {
+		return fmt.Errorf("impossible de créer le répertoire temporaire : %w", err)
+	}
+	defer os.RemoveAll(workDir) // Nettoyer l'archive et le binaire extrait dans tous les cas
+
+	binaryPath, err := fetchCollectorBinary(runtime.GOOS, runtime.GOARCH, workDir)
+	if err != nil {
+		return err
+	}
+
+	// Installer le binaire dans le répertoire système approprié
+	return installBinary(binaryPath)
+}
+
+// fetchCollectorBinary télécharge l'archive du collector pour une plateforme
+// et en extrait le binaire dans workDir
+func fetchCollectorBinary(goos, goarch, workDir string) (string, error) {
 	// Construire l'URL de téléchargement basée sur l'OS et l'architecture
-	downloadURL, filename := getOTelDownloadInfo()
+	downloadURL, filename := getOTelDownloadInfoFor(goos, goarch)
 
-	fmt.Printf("📡 Téléchargement depuis : %s\n", downloadURL)
+	slog.Info("Téléchargement de l'archive du collector", "url", downloadURL)
 
 	// Télécharger l'archive
-	tempFile := filepath.Join(os.TempDir(), filename)
-	if err := downloadFile(downloadURL, tempFile); err != nil {
-		return fmt.Errorf("échec du téléchargement : %w", err)
+	archivePath := filepath.Join(workDir, filename)
+	if err := downloadFile(downloadURL, archivePath); err != nil {
+		return "", fmt.Errorf("échec du téléchargement : %w", err)
 	}
-	defer os.Remove(tempFile) // Nettoyer le fichier temporaire
 
-	fmt.Println("📦 Extraction de l'archive...")
+	slog.Info("Extraction de l'archive", "archive", filename)
 
 	// Extraire le binaire selon le type d'archive
 	var binaryPath string
 	var err error
 
 	if strings.HasSuffix(filename, ".zip") {
-		binaryPath, err = extractFromZip(tempFile)
+		binaryPath, err = extractFromZip(archivePath, workDir, collectorBinaryName(goos))
 	} else {
-		binaryPath, err = extractFromTarGz(tempFile)
+		binaryPath, err = extractFromTarGz(archivePath, workDir, collectorBinaryName(goos))
 	}
 
 	if err != nil {
-		return fmt.Errorf("échec de l'extraction : %w", err)
+		return "", fmt.Errorf("échec de l'extraction : %w", err)
 	}
+	return binaryPath, nil
+}
 
-	// Installer le binaire dans le répertoire système approprié
-	return installBinary(binaryPath)
+// collectorBinaryName retourne le nom exact du binaire attendu dans l'archive
+func collectorBinaryName(goos string) string {
+	if goos == "windows" {
+		return "otelcol-contrib.exe"
+	}
+	return "otelcol-contrib"
 }
 
 // getOTelDownloadInfo retourne l'URL de téléchargement et le nom de fichier
 // pour la version et plateforme actuelles
 func getOTelDownloadInfo() (string, string) {
+	return getOTelDownloadInfoFor(runtime.GOOS, runtime.GOARCH)
+}
+
+// getOTelDownloadInfoFor retourne l'URL de téléchargement et le nom de
+// fichier pour une plateforme donnée (paquets construits pour une autre cible)
+func getOTelDownloadInfoFor(goos, goarch string) (string, string) {
 	baseURL := "https://github.com/open-telemetry/opentelemetry-collector-releases/releases/download"
 
 	var osName, archName, ext string
 
 	// Mapping des noms d'OS Go vers les noms utilisés par OpenTelemetry
-	switch runtime.GOOS {
+	switch goos {
 	case "linux":
 		osName = "linux"
 		ext = "tar.gz"
@@ -67,12 +110,12 @@ func getOTelDownloadInfo() (string, string) {
 		osName = "darwin"
 		ext = "tar.gz"
 	default:
-		osName = runtime.GOOS
+		osName = goos
 		ext = "tar.gz"
 	}
 
 	// Mapping des architectures
-	switch runtime.GOARCH {
+	switch goarch {
 	case "amd64":
 		archName = "amd64"
 	case "arm64":
@@ -80,7 +123,7 @@ func getOTelDownloadInfo() (string, string) {
 	case "386":
 		archName = "386"
 	default:
-		archName = runtime.GOARCH
+		archName = goarch
 	}
 
 	// Construire le nom du fichier
@@ -92,6 +135,21 @@ func getOTelDownloadInfo() (string, string) {
 	return url, filename
 }
 
+// fetchURL télécharge le contenu d'une URL en mémoire (fichiers de configuration)
+func fetchURL(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("mauvais code de statut : %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
 // downloadFile télécharge un fichier depuis une URL vers un chemin local
 func downloadFile(url, filepath string) error {
 	// Créer le fichier de destination
@@ -119,45 +177,43 @@ func downloadFile(url, filepath string) error {
 }
 
 // extractFromZip extrait le binaire otelcol-contrib depuis une archive ZIP (Windows)
-func extractFromZip(zipPath string) (string, error) {
+func extractFromZip(zipPath, destDir, expected string) (string, error) {
 	reader, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return "", err
 	}
 	defer reader.Close()
 
-	// Chercher le binaire principal
 	for _, file := range reader.File {
-		if strings.Contains(file.Name, "otelcol-contrib") && !strings.Contains(file.Name, "/") {
-			// Extraire dans un répertoire temporaire
-			extractPath := filepath.Join(os.TempDir(), "otelcol-contrib.exe")
-
-			rc, err := file.Open()
-			if err != nil {
-				return "", err
-			}
-			defer rc.Close()
-
-			outFile, err := os.Create(extractPath)
-			if err != nil {
-				return "", err
-			}
-			defer outFile.Close()
-
-			_, err = io.Copy(outFile, rc)
-			if err != nil {
-				return "", err
-			}
-
-			return extractPath, nil
+		if err := checkArchiveEntryName(file.Name); err != nil {
+			return "", err
+		}
+		if normalizeArchiveEntryName(file.Name) != expected {
+			continue
 		}
+
+		// Seul un fichier régulier est accepté (pas de lien symbolique ni de répertoire)
+		if !file.Mode().IsRegular() {
+			return "", fmt.Errorf("l'entrée %s de l'archive n'est pas un fichier régulier", file.Name)
+		}
+		if file.UncompressedSize64 > MAX_BINARY_SIZE {
+			return "", fmt.Errorf("l'entrée %s dépasse la taille maximale autorisée (%d octets)", file.Name, MAX_BINARY_SIZE)
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+
+		return extractArchiveEntry(rc, filepath.Join(destDir, expected))
 	}
 
-	return "", fmt.Errorf("binaire otelcol-contrib non trouvé dans l'archive")
+	return "", fmt.Errorf("binaire %s non trouvé dans l'archive", expected)
 }
 
 // extractFromTarGz extrait le binaire depuis une archive tar.gz (Linux/macOS)
-func extractFromTarGz(tarPath string) (string, error) {
+func extractFromTarGz(tarPath, destDir, expected string) (string, error) {
 	file, err := os.Open(tarPath)
 	if err != nil {
 		return "", err
@@ -183,59 +239,114 @@ func extractFromTarGz(tarPath string) (string, error) {
 			return "", err
 		}
 
-		// Chercher le binaire principal
-		if strings.Contains(header.Name, "otelcol-contrib") && !strings.Contains(header.Name, "/") {
-			// Extraire dans un répertoire temporaire
-			extractPath := filepath.Join(os.TempDir(), "otelcol-contrib")
-
-			outFile, err := os.Create(extractPath)
-			if err != nil {
-				return "", err
-			}
-			defer outFile.Close()
-
-			_, err = io.Copy(outFile, tarReader)
-			if err != nil {
-				return "", err
-			}
-
-			// Rendre exécutable (Linux/macOS)
-			err = os.Chmod(extractPath, 0755)
-			if err != nil {
-				return "", err
-			}
-
-			return extractPath, nil
+		if err := checkArchiveEntryName(header.Name); err != nil {
+			return "", err
+		}
+		if normalizeArchiveEntryName(header.Name) != expected {
+			continue
+		}
+
+		// Refuser les liens symboliques, liens physiques et autres types spéciaux
+		if header.Typeflag != tar.TypeReg {
+			return "", fmt.Errorf("l'entrée %s de l'archive n'est pas un fichier régulier", header.Name)
+		}
+		if header.Size > MAX_BINARY_SIZE {
+			return "", fmt.Errorf("l'entrée %s dépasse la taille maximale autorisée (%d octets)", header.Name, MAX_BINARY_SIZE)
 		}
+
+		return extractArchiveEntry(tarReader, filepath.Join(destDir, expected))
 	}
 
-	return "", fmt.Errorf("binaire otelcol-contrib non trouvé dans l'archive")
+	return "", fmt.Errorf("binaire %s non trouvé dans l'archive", expected)
 }
 
-// installBinary copie le binaire extrait vers son emplacement final dans le système
-func installBinary(sourcePath string) error {
-	var destPath string
+// checkArchiveEntryName rejette les entrées absolues ou qui remontent
+// l'arborescence (zip-slip)
+func checkArchiveEntryName(name string) error {
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("entrée d'archive suspecte refusée : %s", name)
+	}
+	return nil
+}
+
+// normalizeArchiveEntryName ramène un nom d'entrée à sa forme canonique
+// (ex: "./otelcol-contrib" -> "otelcol-contrib")
+func normalizeArchiveEntryName(name string) string {
+	return path.Clean(strings.ReplaceAll(name, "\\", "/"))
+}
+
+// extractArchiveEntry écrit le contenu d'une entrée d'archive dans dest en
+// bornant le nombre d'octets décompressés, et supprime le fichier en cas d'échec
+func extractArchiveEntry(r io.Reader, dest string) (string, error) {
+	// O_EXCL : ne jamais écraser ni suivre un fichier préexistant
+	outFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// Lire un octet de plus que la limite pour détecter un dépassement
+	written, err := io.Copy(outFile, io.LimitReader(r, MAX_BINARY_SIZE+1))
+	if err == nil && written > MAX_BINARY_SIZE {
+		err = fmt.Errorf("le binaire décompressé dépasse la taille maximale autorisée (%d octets)", MAX_BINARY_SIZE)
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+
+	return dest, nil
+}
 
+// getBinaryPath retourne l'emplacement final du binaire du collector selon l'OS
+func getBinaryPath() string {
 	switch runtime.GOOS {
 	case "windows":
 		// Sur Windows, installer dans Program Files
-		destPath = `C:\Program Files\SmartSentry\otelcol-contrib.exe`
-		// Créer le répertoire s'il n'existe pas
-		if err := os.MkdirAll(`C:\Program Files\SmartSentry`, 0755); err != nil {
-			return err
-		}
+		return `C:\Program Files\SmartSentry\otelcol-contrib.exe`
 	default:
-		// Sur Linux/macOS, installer dans /usr/local/bin
-		destPath = "/usr/local/bin/otelcol-contrib"
+		// Sur Linux/macOS, installer dans /usr/local/bin (~/.local/bin en mode utilisateur)
+		if userMode {
+			return userHomePath(".local", "bin", "otelcol-contrib")
+		}
+		// Le collector livré par le paquet .deb/.rpm est celui de l'unité
+		if packagedCollector() {
+			return PACKAGE_COLLECTOR_PATH
+		}
+		return "/usr/local/bin/otelcol-contrib"
+	}
+}
+
+// packagedCollector indique si le collector de l'installation système est
+// celui du paquet .deb/.rpm, mis à jour par le gestionnaire de paquets
+func packagedCollector() bool {
+	if runtime.GOOS != "linux" || userMode {
+		return false
+	}
+	_, err := os.Stat(PACKAGE_COLLECTOR_PATH)
+	return err == nil
+}
+
+// installBinary copie le binaire extrait vers son emplacement final dans le système
+func installBinary(sourcePath string) error {
+	destPath := getBinaryPath()
+
+	// Créer le répertoire s'il n'existe pas
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
 	}
 
-	fmt.Printf("📁 Installation du binaire vers : %s\n", destPath)
+	slog.Info("Installation du binaire", "path", destPath)
 
 	// Copier le fichier
 	return copyFile(sourcePath, destPath)
 }
 
-// copyFile copie un fichier depuis src vers dst
+// copyFile copie un fichier depuis src vers dst de manière atomique, en
+// conservant la version précédente de dst sous dst.bak
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -243,25 +354,11 @@ func copyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
+	// Préserver les permissions de la source (0755 pour le binaire extrait)
+	sourceInfo, err := sourceFile.Stat()
 	if err != nil {
 		return err
 	}
 
-	// Préserver les permissions sur Unix
-	if runtime.GOOS != "windows" {
-		sourceInfo, err := os.Stat(src)
-		if err != nil {
-			return err
-		}
-		return os.Chmod(dst, sourceInfo.Mode())
-	}
-
-	return nil
+	return writeFileAtomic(dst, sourceFile, sourceInfo.Mode().Perm())
 }
@@ -0,0 +1,309 @@
+package main
+
+// Protocole d'enrôlement auprès du SmartSentry Gateway (version 1)
+//
+// L'opérateur génère sur le Gateway un jeton d'enrôlement à usage unique et
+// le passe à "install --enroll <jeton>". Le jeton peut embarquer l'adresse du
+// Gateway sous la forme <secret>@<hôte:port> ; sinon --gateway est utilisé.
+// L'échange se fait en https (schéma par défaut) : le certificat du Gateway
+// est vérifié avec les autorités du système ou celles de --enroll-ca, et
+// http:// n'est accepté qu'avec --insecure-enroll.
+//
+// Requête :
+//
+//	POST <gateway>/v1/enroll
+//	Authorization: Bearer <secret>
+//	Content-Type: application/json
+//
+//	{"hostname": "web-01", "os": "linux", "arch": "amd64", "collector_version": "0.128.0"}
+//
+// Réponse 200 :
+//
+//	{
+//	  "endpoint":  "https://gateway.example:4318",  // obligatoire : exporter OTLP HTTP
+//	  "token":     "…",                              // jeton propre à l'agent (Bearer)
+//	  "ca_bundle": "-----BEGIN CERTIFICATE-----…",   // PEM, pour un endpoint https privé
+//	  "tags":      {"env": "prod"}                   // attributs de ressource assignés
+//	}
+//
+// Erreurs : 401 jeton inconnu, 410 jeton expiré ou déjà utilisé, autres codes
+// avec un corps {"error": "…"}. Le Gateway invalide le jeton dès la réponse
+// 200 : un nouvel enrôlement nécessite un nouveau jeton.
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Chemin de l'API d'enrôlement sur le Gateway
+	ENROLL_PATH = "/v1/enroll"
+
+	// Délai maximal de l'appel d'enrôlement
+	ENROLL_TIMEOUT = 30 * time.Second
+
+	// Autorités de certification du Gateway reçues à l'enrôlement
+	GATEWAY_CA_FILE_NAME = "gateway-ca.pem"
+)
+
+// enrollRequest décrit l'agent auprès du Gateway
+type enrollRequest struct {
+	Hostname         string `json:"hostname"`
+	OS               string `json:"os"`
+	Arch             string `json:"arch"`
+	CollectorVersion string `json:"collector_version"`
+}
+
+// enrollResponse contient les paramètres attribués à l'agent
+type enrollResponse struct {
+	Endpoint string            `json:"endpoint"`
+	Token    string            `json:"token"`
+	CABundle string            `json:"ca_bundle"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// enrollError est le corps des réponses d'erreur du Gateway
+type enrollError struct {
+	Error string `json:"error"`
+}
+
+// splitEnrollToken sépare le secret de l'adresse éventuellement embarquée
+func splitEnrollToken(token string) (string, string) {
+	if i := strings.LastIndex(token, "@"); i >= 0 {
+		return token[:i], token[i+1:]
+	}
+	return token, ""
+}
+
+// normalizeEnrollURL complète l'adresse d'enrôlement avec https:// et refuse
+// http:// sans acceptation explicite : le jeton y circulerait en clair
+func normalizeEnrollURL(gatewayURL string, insecure bool) (string, error) {
+	gatewayURL = strings.TrimSpace(gatewayURL)
+	switch {
+	case gatewayURL == "":
+		return "", fmt.Errorf("adresse du Gateway inconnue : utilisez --gateway ou un jeton <secret>@<hôte:port>")
+	case strings.HasPrefix(gatewayURL, "https://"):
+		return gatewayURL, nil
+	case strings.HasPrefix(gatewayURL, "http://"):
+		if !insecure {
+			return "", fmt.Errorf("enrôlement en clair refusé (%s) : utilisez https:// ou --insecure-enroll", gatewayURL)
+		}
+		slog.Warn("Enrôlement en clair : le jeton d'enrôlement et celui de l'agent transitent sans chiffrement", "gateway", gatewayURL)
+		return gatewayURL, nil
+	default:
+		return "https://" + gatewayURL, nil
+	}
+}
+
+// loadCAFile lit un fichier PEM d'autorités de certification (--enroll-ca,
+// tls.ca_file de l'exporter)
+func loadCAFile(path string) (*x509.CertPool, []byte, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("impossible de lire %s : %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, nil, fmt.Errorf("aucun certificat PEM dans %s", path)
+	}
+	return pool, bundle, nil
+}
+
+// resolveEnrollConfigPolicy fixe la politique appliquée à une configuration
+// existante avant l'enrôlement : la conserver perdrait l'endpoint et le jeton
+// obtenus avec un jeton d'enrôlement désormais consommé
+func resolveEnrollConfigPolicy(policy string) (string, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		return policy, nil
+	}
+
+	if policy == "" {
+		if !isInteractive() {
+			return "", fmt.Errorf("une configuration existe déjà (%s) : précisez --config-policy replace, backup ou merge", configPath)
+		}
+		fmt.Printf("Une configuration existe déjà (%s) et sera mise à jour avec le résultat de l'enrôlement.\n", configPath)
+		if policy, err = promptForConfigPolicy(); err != nil {
+			return "", err
+		}
+	}
+	if policy == CONFIG_POLICY_KEEP {
+		return "", fmt.Errorf("la configuration existante serait conservée : le résultat de l'enrôlement serait perdu")
+	}
+	return policy, nil
+}
+
+// enrollAgent échange le jeton d'enrôlement contre l'endpoint, le jeton de
+// l'agent, les autorités de certification et les tags, puis les reporte dans
+// les options d'installation ; les tags passés en --tag restent prioritaires
+func enrollAgent(opts *installOptions) error {
+	secret, gatewayURL := splitEnrollToken(opts.Enroll)
+	if gatewayURL == "" {
+		gatewayURL = opts.GatewayURL
+	}
+	if secret == "" {
+		return fmt.Errorf("jeton d'enrôlement vide")
+	}
+	gatewayURL, err := normalizeEnrollURL(gatewayURL, opts.InsecureEnroll)
+	if err != nil {
+		return err
+	}
+
+	var rootCAs *x509.CertPool
+	var enrollCA []byte
+	if opts.EnrollCA != "" {
+		if rootCAs, enrollCA, err = loadCAFile(opts.EnrollCA); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Enrôlement auprès du Gateway", "gateway", gatewayURL)
+	response, err := requestEnrollment(gatewayURL, secret, rootCAs)
+	if err != nil {
+		return err
+	}
+
+	// Sans ca_bundle dans la réponse, les autorités qui ont vérifié le Gateway
+	// à l'enrôlement servent aussi à l'exporter
+	if response.CABundle == "" && enrollCA != nil {
+		response.CABundle = string(enrollCA)
+	}
+
+	opts.GatewayURL = normalizeGatewayURL(response.Endpoint)
+	opts.Token = response.Token
+	for key, value := range response.Tags {
+		if _, ok := opts.Tags[key]; !ok {
+			opts.Tags[key] = value
+		}
+	}
+
+	if response.CABundle != "" {
+		caPath, err := writeGatewayCA([]byte(response.CABundle))
+		if err != nil {
+			return err
+		}
+		opts.CAFile = caPath
+		slog.Info("Autorités de certification du Gateway enregistrées", "path", caPath)
+	}
+
+	slog.Info("Agent enrôlé", "endpoint", opts.GatewayURL, "tags", strings.Join(sortedKeys(tagKeys(response.Tags)), ", "))
+	return nil
+}
+
+// requestEnrollment effectue l'appel POST /v1/enroll ; rootCAs remplace les
+// autorités du système pour vérifier le certificat du Gateway (nil : système)
+func requestEnrollment(gatewayURL, secret string, rootCAs *x509.CertPool) (*enrollResponse, error) {
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(enrollRequest{
+		Hostname:         hostname,
+		OS:               runtime.GOOS,
+		Arch:             runtime.GOARCH,
+		CollectorVersion: OTEL_VERSION,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(gatewayURL, "/")+ENROLL_PATH, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	client := &http.Client{Timeout: ENROLL_TIMEOUT, Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Gateway injoignable : %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("réponse du Gateway illisible : %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("jeton d'enrôlement refusé par le Gateway")
+	case http.StatusGone:
+		return nil, fmt.Errorf("jeton d'enrôlement expiré ou déjà utilisé")
+	default:
+		var gatewayErr enrollError
+		if json.Unmarshal(data, &gatewayErr) == nil && gatewayErr.Error != "" {
+			return nil, fmt.Errorf("enrôlement refusé (%d) : %s", resp.StatusCode, gatewayErr.Error)
+		}
+		return nil, fmt.Errorf("enrôlement refusé : mauvais code de statut : %d", resp.StatusCode)
+	}
+
+	var response enrollResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("réponse d'enrôlement invalide : %w", err)
+	}
+	if response.Endpoint == "" {
+		return nil, fmt.Errorf("réponse d'enrôlement invalide : endpoint absent")
+	}
+	if response.CABundle != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(response.CABundle)) {
+		return nil, fmt.Errorf("réponse d'enrôlement invalide : aucun certificat PEM dans ca_bundle")
+	}
+	return &response, nil
+}
+
+// getGatewayCAPath retourne l'emplacement des autorités de certification du Gateway
+func getGatewayCAPath() (string, error) {
+	configDir, err := getConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, GATEWAY_CA_FILE_NAME), nil
+}
+
+// writeGatewayCA enregistre les autorités de certification du Gateway à côté
+// de la configuration (certificats publics, lisibles par le service)
+func writeGatewayCA(bundle []byte) (string, error) {
+	caPath, err := getGatewayCAPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(caPath), 0755); err != nil {
+		return "", fmt.Errorf("impossible de créer %s : %w", filepath.Dir(caPath), err)
+	}
+	if err := writeBytesAtomic(caPath, bundle, 0644); err != nil {
+		return "", fmt.Errorf("impossible d'écrire %s : %w", caPath, err)
+	}
+	return caPath, nil
+}
+
+// setGatewayTLS fait vérifier le certificat du Gateway avec caFile au lieu
+// d'accepter une connexion non vérifiée
+func setGatewayTLS(root *yaml.Node, caFile string) {
+	exporters := yamlGet(root, "exporters")
+	for i := 0; exporters != nil && i+1 < len(exporters.Content); i += 2 {
+		if componentType(exporters.Content[i].Value) != "otlphttp" || exporters.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		tls := yamlEnsureMapping(exporters.Content[i+1], "tls")
+		yamlDelete(tls, "insecure")
+		yamlDelete(tls, "insecure_skip_verify")
+		yamlSet(tls, "ca_file", yamlScalar(caFile))
+	}
+}
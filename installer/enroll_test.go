@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTestDirs redirige la configuration et l'état de l'agent vers un
+// répertoire temporaire (chemins du mode utilisateur)
+func useTestDirs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	t.Setenv("ProgramData", dir)
+
+	previous := userMode
+	userMode = true
+	t.Cleanup(func() { userMode = previous })
+	return dir
+}
+
+// serverCertificatePEM retourne le certificat d'un serveur httptest TLS au format PEM
+func serverCertificatePEM(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+// enrollHandler simule l'API d'enrôlement du Gateway : vérifie la requête
+// puis répond avec le statut et le corps donnés
+func enrollHandler(t *testing.T, status int, body interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != ENROLL_PATH {
+			t.Errorf("requête %s %s, attendu POST %s", r.Method, r.URL.Path, ENROLL_PATH)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer s3cret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var request enrollRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.OS == "" || request.CollectorVersion != OTEL_VERSION {
+			t.Errorf("description de l'agent invalide : %+v (%v)", request, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func TestRequestEnrollment(t *testing.T) {
+	// Un certificat quelconque suffit comme ca_bundle valide
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	caBundle := serverCertificatePEM(tlsServer)
+
+	tests := []struct {
+		name    string
+		status  int
+		body    interface{}
+		wantErr string
+	}{
+		{"succès", http.StatusOK, enrollResponse{
+			Endpoint: "https://gateway.example:4318",
+			Token:    "agent-token",
+			CABundle: caBundle,
+			Tags:     map[string]string{"env": "prod"},
+		}, ""},
+		{"jeton inconnu", http.StatusUnauthorized, enrollError{Error: "unknown token"}, "refusé"},
+		{"jeton consommé", http.StatusGone, enrollError{Error: "token used"}, "expiré ou déjà utilisé"},
+		{"autre erreur", http.StatusForbidden, enrollError{Error: "host not allowed"}, "host not allowed"},
+		{"endpoint absent", http.StatusOK, enrollResponse{Token: "agent-token"}, "endpoint absent"},
+		{"ca_bundle invalide", http.StatusOK, enrollResponse{
+			Endpoint: "https://gateway.example:4318",
+			CABundle: "-----BEGIN CERTIFICATE-----\nnot base64\n-----END CERTIFICATE-----\n",
+		}, "ca_bundle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(enrollHandler(t, tt.status, tt.body))
+			defer server.Close()
+
+			response, err := requestEnrollment(server.URL, "s3cret", nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("erreur %v, attendu %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Endpoint != "https://gateway.example:4318" || response.Token != "agent-token" ||
+				response.CABundle != caBundle || response.Tags["env"] != "prod" {
+				t.Errorf("réponse inattendue : %+v", response)
+			}
+		})
+	}
+}
+
+func TestEnrollAgent(t *testing.T) {
+	useTestDirs(t)
+
+	server := httptest.NewTLSServer(enrollHandler(t, http.StatusOK, enrollResponse{
+		Endpoint: "https://gateway.example:4318",
+		Token:    "agent-token",
+		Tags:     map[string]string{"env": "prod", "team": "infra"},
+	}))
+	defer server.Close()
+
+	// Le Gateway n'envoie pas de ca_bundle : les autorités de --enroll-ca
+	// vérifient l'enrôlement puis l'exporter
+	caFile := filepath.Join(t.TempDir(), "enroll-ca.pem")
+	if err := os.WriteFile(caFile, []byte(serverCertificatePEM(server)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := installOptions{
+		Enroll:   "s3cret@" + strings.TrimPrefix(server.URL, "https://"),
+		EnrollCA: caFile,
+		Tags:     tagFlag{"env": "staging"},
+	}
+	if err := enrollAgent(&opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.GatewayURL != "https://gateway.example:4318" || opts.Token != "agent-token" {
+		t.Errorf("endpoint %q, jeton %q", opts.GatewayURL, opts.Token)
+	}
+	// --tag l'emporte sur les tags attribués par le Gateway
+	if opts.Tags["env"] != "staging" || opts.Tags["team"] != "infra" {
+		t.Errorf("tags %v", opts.Tags)
+	}
+
+	expectedCA, _ := getGatewayCAPath()
+	if opts.CAFile != expectedCA {
+		t.Fatalf("CAFile %q, attendu %q", opts.CAFile, expectedCA)
+	}
+	if stored, err := os.ReadFile(opts.CAFile); err != nil || string(stored) != serverCertificatePEM(server) {
+		t.Errorf("autorités du Gateway non enregistrées (%v)", err)
+	}
+}
+
+func TestEnrollAgentRequiresVerifiedHTTPS(t *testing.T) {
+	useTestDirs(t)
+	body := enrollResponse{Endpoint: "https://gateway.example:4318", Token: "agent-token"}
+
+	plain := httptest.NewServer(enrollHandler(t, http.StatusOK, body))
+	defer plain.Close()
+	tlsServer := httptest.NewTLSServer(enrollHandler(t, http.StatusOK, body))
+	defer tlsServer.Close()
+
+	t.Run("http refusé", func(t *testing.T) {
+		opts := installOptions{Enroll: "s3cret", GatewayURL: plain.URL, Tags: tagFlag{}}
+		if err := enrollAgent(&opts); err == nil || !strings.Contains(err.Error(), "--insecure-enroll") {
+			t.Fatalf("erreur %v", err)
+		}
+	})
+	t.Run("http avec --insecure-enroll", func(t *testing.T) {
+		opts := installOptions{Enroll: "s3cret", GatewayURL: plain.URL, InsecureEnroll: true, Tags: tagFlag{}}
+		if err := enrollAgent(&opts); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("certificat non reconnu", func(t *testing.T) {
+		opts := installOptions{Enroll: "s3cret@" + tlsServer.URL, Tags: tagFlag{}}
+		if err := enrollAgent(&opts); err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Fatalf("erreur %v", err)
+		}
+	})
+}
+
+func TestNormalizeEnrollURL(t *testing.T) {
+	tests := []struct {
+		in       string
+		insecure bool
+		want     string
+		wantErr  bool
+	}{
+		{"gateway.example:4318", false, "https://gateway.example:4318", false},
+		{"https://gateway.example", false, "https://gateway.example", false},
+		{"http://gateway.example", false, "", true},
+		{"http://gateway.example", true, "http://gateway.example", false},
+		{"", false, "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeEnrollURL(tt.in, tt.insecure)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeEnrollURL(%q, %v) = %q, %v", tt.in, tt.insecure, got, err)
+		}
+	}
+}
+
+func TestResolveEnrollConfigPolicy(t *testing.T) {
+	useTestDirs(t)
+
+	// Sans configuration existante, la politique est sans objet
+	if policy, err := resolveEnrollConfigPolicy(""); err != nil || policy != "" {
+		t.Fatalf("sans configuration : %q, %v", policy, err)
+	}
+
+	configPath, _ := getConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte("receivers: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := resolveEnrollConfigPolicy(CONFIG_POLICY_KEEP); err == nil {
+		t.Error("keep accepté alors que le résultat de l'enrôlement serait perdu")
+	}
+	if policy, err := resolveEnrollConfigPolicy(CONFIG_POLICY_MERGE); err != nil || policy != CONFIG_POLICY_MERGE {
+		t.Errorf("merge : %q, %v", policy, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Fichier d'environnement lu par systemd (EnvironmentFile=) : secrets et
+	// paramètres réglables référencés par config.yaml via ${env:...}
+	ENV_FILE_NAME = "agent.env"
+
+	// Variables d'environnement référencées par la configuration générée
+	ENV_GATEWAY_ENDPOINT = "SMARTSENTRY_GATEWAY_ENDPOINT"
+	ENV_TOKEN            = "SMARTSENTRY_TOKEN"
+)
+
+// envReferencePattern reconnaît les références ${env:NOM} du collector
+var envReferencePattern = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// getEnvFilePath retourne le chemin du fichier d'environnement de l'agent
+func getEnvFilePath() (string, error) {
+	configDir, err := getConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, ENV_FILE_NAME), nil
+}
+
+// envReference retourne la référence ${env:NOM} à placer dans la configuration
+func envReference(name string) string {
+	return "${env:" + name + "}"
+}
+
+// readEnvFile lit un fichier KEY=valeur au format EnvironmentFile de systemd
+func readEnvFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+// writeEnvFile écrit le fichier d'environnement en 0600 ; le propriétaire
+// d'un fichier existant (root:smartsentry) est conservé par writeBytesAtomic
+func writeEnvFile(path string, values map[string]string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Généré par smartsentry-installer : secrets et paramètres de SmartSentry Agent.\n")
+	buf.WriteString("# Référencé par l'unité systemd (EnvironmentFile=) et par config.yaml via ${env:NOM}.\n")
+	buf.WriteString("# Après modification : sudo systemctl restart " + SERVICE_NAME + "\n\n")
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s=%s\n", key, strconv.Quote(values[key]))
+	}
+
+	return writeBytesAtomic(path, buf.Bytes(), 0600)
+}
+
+// updateEnvFile fusionne des valeurs dans le fichier d'environnement existant
+func updateEnvFile(path string, updates map[string]string) error {
+	values, err := readEnvFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		values = map[string]string{}
+	}
+	for key, value := range updates {
+		values[key] = value
+	}
+	return writeEnvFile(path, values)
+}
+
+// secureEnvFile attribue le fichier d'environnement à root:<group> en 0600 :
+// systemd le lit en tant que root, le groupe sert à l'identification
+func secureEnvFile(path, group string) error {
+	grp, err := user.LookupGroup(group)
+	if err != nil {
+		return fmt.Errorf("groupe %s introuvable : %w", group, err)
+	}
+	gid, err := strconv.Atoi(grp.Gid)
+	if err != nil {
+		return fmt.Errorf("GID invalide pour %s : %w", group, err)
+	}
+	if err := os.Chown(path, 0, gid); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// expandEnvReferences remplace les références ${env:NOM} par leur valeur
+func expandEnvReferences(value string, env map[string]string) string {
+	return envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReferencePattern.FindStringSubmatch(reference)[1]
+		if resolved, ok := env[name]; ok {
+			return resolved
+		}
+		return os.Getenv(name)
+	})
+}
+
+// environWith retourne l'environnement du processus complété par des valeurs
+// (pour exécuter le collector hors systemd, ex: validate)
+func environWith(values map[string]string) []string {
+	environ := os.Environ()
+	for key, value := range values {
+		environ = append(environ, key+"="+value)
+	}
+	return environ
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// AGENT_ID_FILE est le fichier du répertoire d'état contenant l'identifiant
+// stable de l'agent (UUIDv7), conservé entre les mises à jour et réinstallations
+const AGENT_ID_FILE = "agent-id"
+
+// newUUIDv7 génère un UUID version 7 (RFC 9562) : horodatage en millisecondes
+// sur 48 bits suivi de bits aléatoires
+func newUUIDv7() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // variante RFC 9562
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// loadAgentID retourne l'identifiant enregistré de l'agent (repris de
+// l'ancien fichier OpAMP si besoin) ; à défaut, un nouvel identifiant qui
+// n'est enregistré par saveAgentID qu'une fois écrite la configuration qui
+// l'utilise (stored vaut alors false)
+func loadAgentID() (id string, stored bool, err error) {
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return "", false, err
+	}
+	path := filepath.Join(stateDir, AGENT_ID_FILE)
+
+	if content, err := os.ReadFile(path); err == nil {
+		if id := strings.TrimSpace(string(content)); id != "" {
+			return id, true, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", false, fmt.Errorf("impossible de lire %s : %w", path, err)
+	}
+
+	// Une installation antérieure a pu annoncer un identifiant au serveur
+	// OpAMP : il est repris pour ne pas faire apparaître un nouvel agent
+	if content, err := os.ReadFile(filepath.Join(stateDir, OPAMP_INSTANCE_UID_FILE)); err == nil {
+		if id := strings.TrimSpace(string(content)); id != "" {
+			return id, false, nil
+		}
+	}
+
+	id, err = newUUIDv7()
+	if err != nil {
+		return "", false, fmt.Errorf("impossible de générer l'identifiant de l'agent : %w", err)
+	}
+	return id, false, nil
+}
+
+// saveAgentID enregistre l'identifiant de l'agent (premier enregistrement,
+// reprise de l'ancien fichier OpAMP ou --reset-identity : machine clonée,
+// changement de rôle) une fois la configuration qui l'utilise écrite
+func saveAgentID(id string, reset bool) error {
+	stateDir, err := ensureStateDirectory()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir, AGENT_ID_FILE)
+	if err := writeBytesAtomic(path, []byte(id+"\n"), 0644); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", path, err)
+	}
+	legacyPath := filepath.Join(stateDir, OPAMP_INSTANCE_UID_FILE)
+	if err := os.Remove(legacyPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("impossible de supprimer %s : %w", legacyPath, err)
+	}
+	if reset {
+		slog.Info("Identifiant de l'agent réinitialisé", "agent_id", id)
+	} else {
+		slog.Info("Identifiant de l'agent enregistré", "agent_id", id)
+	}
+	return nil
+}
+
+// machineIDPaths sont les emplacements du machine-id systemd/D-Bus
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// readMachineID retourne le machine-id de l'hôte, à titre de référence (il est
+// dupliqué par le clonage d'une image non préparée) ; vide hors Linux
+func readMachineID() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	for _, path := range machineIDPaths {
+		if content, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(content)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Nom commun des objets Kubernetes générés par export k8s
+	K8S_APP_NAME = "smartsentry-agent"
+
+	// Répertoire de montage de la ConfigMap dans le conteneur
+	K8S_CONFIG_DIR = "/etc/smartsentry-agent"
+
+	// Variables injectées par l'API descendante (downward API)
+	K8S_ENV_NODE_NAME = "K8S_NODE_NAME"
+	K8S_ENV_POD_IP    = "K8S_POD_IP"
+)
+
+// k8sObject est un objet Kubernetes sérialisé dans le flux YAML exporté
+type k8sObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	StringData map[string]string `yaml:"stringData,omitempty"`
+	Rules      []interface{}     `yaml:"rules,omitempty"`
+	RoleRef    map[string]string `yaml:"roleRef,omitempty"`
+	Subjects   []interface{}     `yaml:"subjects,omitempty"`
+	Spec       interface{}       `yaml:"spec,omitempty"`
+}
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+// k8sExportOptions regroupe les paramètres de export k8s
+type k8sExportOptions struct {
+	GatewayURL string
+	Token      string
+	Profile    string
+	Tags       tagFlag
+	Namespace  string
+	Image      string
+}
+
+// runExport traite la commande export (seule la cible k8s existe)
+func runExport(args []string) error {
+	if len(args) == 0 || args[0] != "k8s" {
+		return fmt.Errorf("usage : export k8s [options]")
+	}
+
+	opts := k8sExportOptions{Tags: tagFlag{}}
+	fs := flag.NewFlagSet("export k8s", flag.ExitOnError)
+	fs.StringVar(&opts.GatewayURL, "gateway", "", "URL du SmartSentry Gateway (ex: http://192.168.1.100:30080)")
+	fs.StringVar(&opts.Token, "token", "", "jeton d'authentification envoyé au Gateway, stocké dans un Secret")
+	fs.StringVar(&opts.Profile, "profile", PROFILE_STANDARD, "profil de collecte : minimal, standard ou full (processus et journaux système)")
+	fs.Var(opts.Tags, "tag", "attribut de ressource ajouté aux données, au format clé=valeur (répétable)")
+	fs.StringVar(&opts.Namespace, "namespace", "smartsentry", "namespace Kubernetes des objets générés")
+	fs.StringVar(&opts.Image, "image", collectorImage(), "image du collector")
+	templatePath := fs.String("config-template", "", "modèle de configuration local (téléchargé depuis le dépôt si vide)")
+	output := fs.String("output", "-", "fichier YAML à écrire (- pour la sortie standard)")
+	fs.Parse(args[1:])
+
+	opts.GatewayURL = normalizeGatewayURL(opts.GatewayURL)
+	if opts.GatewayURL == "" {
+		return fmt.Errorf("l'option --gateway est obligatoire")
+	}
+	if !validProfile(opts.Profile) {
+		return fmt.Errorf("profil inconnu : %s (minimal, standard ou full)", opts.Profile)
+	}
+
+	// Même modèle Linux que l'installation sur l'hôte
+	var template []byte
+	var err error
+	if *templatePath != "" {
+		template, err = os.ReadFile(*templatePath)
+	} else {
+		template, err = fetchURL(CONFIG_BASE_URL + "/linux-default-config.yaml")
+	}
+	if err != nil {
+		return fmt.Errorf("impossible de récupérer le modèle de configuration : %w", err)
+	}
+
+	manifests, err := buildK8sManifests(template, opts)
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		_, err = os.Stdout.Write(manifests)
+		return err
+	}
+	if err := os.WriteFile(*output, manifests, 0600); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", *output, err)
+	}
+	slog.Info("Manifestes Kubernetes écrits", "path", *output, "apply", "kubectl apply -f "+*output)
+	return nil
+}
+
+// buildK8sManifests génère le flux YAML multi-documents : Namespace,
+// ServiceAccount, RBAC, Secret éventuel, ConfigMap et DaemonSet
+func buildK8sManifests(template []byte, opts k8sExportOptions) ([]byte, error) {
+	// La configuration est produite par le même générateur que pour l'hôte ;
+	// l'endpoint et le jeton sont fournis par l'environnement du conteneur
+	rendered, err := renderConfig(template, renderOptions{
+		GatewayURL: opts.GatewayURL,
+		Token:      opts.Token,
+		UseEnvFile: true,
+		Profile:    opts.Profile,
+		TargetOS:   "linux",
+		Tags:       opts.Tags,
+		Container:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	config, err := adaptForKubernetes(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{"app.kubernetes.io/name": K8S_APP_NAME}
+	meta := k8sMetadata{Name: K8S_APP_NAME, Namespace: opts.Namespace, Labels: labels}
+	clusterMeta := k8sMetadata{Name: K8S_APP_NAME, Labels: labels}
+
+	objects := []k8sObject{
+		{APIVersion: "v1", Kind: "Namespace", Metadata: k8sMetadata{Name: opts.Namespace}},
+		{APIVersion: "v1", Kind: "ServiceAccount", Metadata: meta},
+		// Le détecteur k8snode lit l'objet Node pour k8s.node.name et k8s.node.uid
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Metadata: clusterMeta,
+			Rules: []interface{}{map[string]interface{}{
+				"apiGroups": []string{""},
+				"resources": []string{"nodes"},
+				"verbs":     []string{"get", "list", "watch"},
+			}}},
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding", Metadata: clusterMeta,
+			RoleRef: map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": K8S_APP_NAME},
+			Subjects: []interface{}{map[string]string{
+				"kind": "ServiceAccount", "name": K8S_APP_NAME, "namespace": opts.Namespace,
+			}}},
+	}
+	if opts.Token != "" {
+		objects = append(objects, k8sObject{APIVersion: "v1", Kind: "Secret", Metadata: meta,
+			Type: "Opaque", StringData: map[string]string{ENV_TOKEN: opts.Token}})
+	}
+	objects = append(objects,
+		k8sObject{APIVersion: "v1", Kind: "ConfigMap", Metadata: meta, Data: map[string]string{"config.yaml": string(config)}},
+		k8sObject{APIVersion: "apps/v1", Kind: "DaemonSet", Metadata: meta, Spec: daemonSetSpec(opts, labels, config)},
+	)
+
+	var buf bytes.Buffer
+	for i, object := range objects {
+		data, err := marshalYAML(object)
+		if err != nil {
+			return nil, fmt.Errorf("impossible de sérialiser %s : %w", object.Kind, err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// adaptForKubernetes complète la configuration rendue pour un pod : nom du
+// nœud comme host.name, détecteur k8snode et health_check joignable par le kubelet
+func adaptForKubernetes(rendered []byte) ([]byte, error) {
+	doc, err := parseYAMLDocument(rendered)
+	if err != nil {
+		return nil, err
+	}
+	root := yamlRoot(doc)
+
+	// Le hostname du pod n'est pas celui du nœud : host.name est forcé en tête
+	// des attributs pour que service.instance.id en soit dérivé
+	if err := setResourceTag(root, "host.name", envReference(K8S_ENV_NODE_NAME)); err != nil {
+		return nil, err
+	}
+	attributes := yamlLookup(root, "processors", "resource", "attributes")
+	last := len(attributes.Content) - 1
+	attributes.Content = append([]*yaml.Node{attributes.Content[last]}, attributes.Content[:last]...)
+
+	if detection := yamlLookup(root, "processors", "resourcedetection"); detection != nil && detection.Kind == yaml.MappingNode {
+		yamlAppendUnique(detection, "detectors", "k8snode")
+		yamlSet(detection, "k8snode", yamlMapping("auth_type", "serviceAccount", "node_from_env_var", K8S_ENV_NODE_NAME))
+	}
+
+	_, port, _ := net.SplitHostPort(HEALTH_CHECK_ENDPOINT)
+	enableExtension(root, "health_check", envReference(K8S_ENV_POD_IP)+":"+port)
+
+	return marshalConfigDocument(doc)
+}
+
+// daemonSetSpec décrit le DaemonSet : un collector par nœud, racine de l'hôte
+// montée en lecture seule sous HOSTFS_ROOT
+func daemonSetSpec(opts k8sExportOptions, labels map[string]string, config []byte) map[string]interface{} {
+	env := []interface{}{
+		map[string]interface{}{"name": K8S_ENV_NODE_NAME, "valueFrom": map[string]interface{}{
+			"fieldRef": map[string]string{"fieldPath": "spec.nodeName"}}},
+		map[string]interface{}{"name": K8S_ENV_POD_IP, "valueFrom": map[string]interface{}{
+			"fieldRef": map[string]string{"fieldPath": "status.podIP"}}},
+		map[string]interface{}{"name": ENV_GATEWAY_ENDPOINT, "value": opts.GatewayURL},
+	}
+	if opts.Token != "" {
+		env = append(env, map[string]interface{}{"name": ENV_TOKEN, "valueFrom": map[string]interface{}{
+			"secretKeyRef": map[string]string{"name": K8S_APP_NAME, "key": ENV_TOKEN}}})
+	}
+
+	volumes := []interface{}{
+		map[string]interface{}{"name": "config", "configMap": map[string]string{"name": K8S_APP_NAME}},
+	}
+	mounts := []interface{}{
+		map[string]interface{}{"name": "config", "mountPath": K8S_CONFIG_DIR, "readOnly": true},
+	}
+	addHostPath := func(name, hostPath, mountPath string) {
+		volumes = append(volumes, map[string]interface{}{"name": name, "hostPath": map[string]string{"path": hostPath}})
+		mounts = append(mounts, map[string]interface{}{"name": name, "mountPath": mountPath, "readOnly": true})
+	}
+	// La racine sert aux scrapers filesystem et disk ; /proc et /sys sont montés
+	// explicitement car un montage de / n'inclut pas ses sous-montages
+	addHostPath("hostfs", "/", HOSTFS_ROOT)
+	addHostPath("hostfs-proc", "/proc", HOSTFS_ROOT+"/proc")
+	addHostPath("hostfs-sys", "/sys", HOSTFS_ROOT+"/sys")
+	if opts.Profile == PROFILE_FULL {
+		addHostPath("varlog", "/var/log", "/var/log")
+	}
+
+	securityContext := map[string]interface{}{
+		"readOnlyRootFilesystem":   true,
+		"allowPrivilegeEscalation": false,
+	}
+	if opts.Profile == PROFILE_FULL {
+		// Processus des autres utilisateurs et journaux système réservés à root
+		securityContext["runAsUser"] = 0
+	}
+
+	_, port, _ := net.SplitHostPort(HEALTH_CHECK_ENDPOINT)
+	portNumber, _ := strconv.Atoi(port)
+	probe := map[string]interface{}{"httpGet": map[string]interface{}{"path": "/", "port": portNumber}}
+
+	// L'empreinte de la configuration redémarre les pods quand elle change
+	checksum := sha256.Sum256(config)
+
+	return map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": labels},
+		"template": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels":      labels,
+				"annotations": map[string]string{"checksum/config": hex.EncodeToString(checksum[:])},
+			},
+			"spec": map[string]interface{}{
+				"serviceAccountName": K8S_APP_NAME,
+				// Un agent sur chaque nœud, control-plane compris
+				"tolerations": []interface{}{map[string]string{"operator": "Exists"}},
+				"containers": []interface{}{map[string]interface{}{
+					"name":            "otel-collector",
+					"image":           opts.Image,
+					"args":            []string{"--config=" + K8S_CONFIG_DIR + "/config.yaml"},
+					"env":             env,
+					"volumeMounts":    mounts,
+					"securityContext": securityContext,
+					"livenessProbe":   probe,
+					"readinessProbe":  probe,
+					"resources": map[string]interface{}{
+						"requests": map[string]string{"cpu": "50m", "memory": "64Mi"},
+						"limits":   map[string]string{"memory": "256Mi"},
+					},
+				}},
+				"volumes": volumes,
+			},
+		},
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Journal complet (niveau debug) de l'installateur, à joindre aux demandes
+	// de support : messages, commandes exécutées, codes de sortie et sorties
+	INSTALLER_LOG_FILE_NAME = "installer.log"
+
+	// Taille au-delà de laquelle installer.log est renommé en installer.log.1
+	INSTALLER_LOG_MAX_SIZE = 5 << 20
+
+	// Formats des messages affichés sur la console (--log-format)
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// secretFlags sont les options (et clés de config set) dont la valeur n'est
+// jamais journalisée
+var (
+	secretFlags = []string{"token", "opamp-token", "enroll"}
+	secretKeys  = []string{"gateway.token"}
+)
+
+// loggingOptions regroupe les options globales de journalisation, acceptées
+// par toutes les commandes. Elles ne concernent que les messages journalisés :
+// les rapports de doctor, status et verify sont le résultat de la commande et
+// restent sur stdout, au format lisible ou JSON (--json), quel que soit
+// --log-format
+type loggingOptions struct {
+	Format  string
+	Verbose bool
+}
+
+// parseLoggingFlags retire des arguments les options --log-format et
+// --verbose, placées avant ou après la commande, avant l'analyse propre à
+// chaque commande. Les arguments qui suivent -- sont transmis tels quels.
+func parseLoggingFlags(args []string) (loggingOptions, []string, error) {
+	opts := loggingOptions{Format: LOG_FORMAT_TEXT}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") {
+			rest = append(rest, args[i])
+			continue
+		}
+		switch name {
+		case "verbose":
+			opts.Verbose = !hasValue || value == "true"
+		case "log-format":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return opts, nil, fmt.Errorf("--log-format nécessite une valeur (text ou json)")
+				}
+				i++
+				value = args[i]
+			}
+			if value != LOG_FORMAT_TEXT && value != LOG_FORMAT_JSON {
+				return opts, nil, fmt.Errorf("valeur invalide pour --log-format : %s (text ou json)", value)
+			}
+			opts.Format = value
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return opts, rest, nil
+}
+
+// setupLogging installe le logger par défaut : console (stderr, pour laisser
+// stdout aux sorties des commandes) au niveau info ou debug avec --verbose,
+// et fichier installer.log toujours au niveau debug
+func setupLogging(opts loggingOptions, command string, args []string) {
+	level := slog.LevelInfo
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	var console slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level, ReplaceAttr: dropTime})
+	if opts.Format == LOG_FORMAT_JSON {
+		console = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	}
+
+	// Sans droits d'écriture (commande de consultation lancée sans sudo),
+	// seule la console est utilisée
+	file, path := openInstallerLog()
+	if file == nil {
+		slog.SetDefault(slog.New(console))
+		return
+	}
+	slog.SetDefault(slog.New(teeHandler{console, slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+	closeLogging = func() { file.Close() }
+
+	slog.Debug("Démarrage de smartsentry-installer", "command", command, "args", strings.Join(maskSecretArgs(args), " "), "log_file", path)
+}
+
+// openInstallerLog ouvre installer.log en ajout, dans le répertoire de
+// l'utilisateur si userMode est actif ; nil si impossible
+func openInstallerLog() (*os.File, string) {
+	logDir, err := platformLogDirectory()
+	if err != nil {
+		return nil, ""
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, ""
+	}
+	path := filepath.Join(logDir, INSTALLER_LOG_FILE_NAME)
+	if info, err := os.Stat(path); err == nil && info.Size() > INSTALLER_LOG_MAX_SIZE {
+		os.Rename(path, path+".1")
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, ""
+	}
+	return file, path
+}
+
+// hasFlag indique si le booléen --name est activé dans les arguments, sous
+// les formes -name, --name ou --name=true acceptées par le package flag
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flagName, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if flagName != name {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		enabled, err := strconv.ParseBool(value)
+		return err == nil && enabled
+	}
+	return false
+}
+
+// maskSecretArgs masque les jetons passés en option avant journalisation
+func maskSecretArgs(args []string) []string {
+	masked := append([]string(nil), args...)
+	for i, arg := range masked {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			if hasValue && containsString(secretKeys, name) {
+				masked[i] = name + "=****"
+			}
+			continue
+		}
+		if !containsString(secretFlags, name) {
+			continue
+		}
+		if hasValue {
+			masked[i] = arg[:strings.Index(arg, "=")+1] + "****"
+		} else if i+1 < len(masked) {
+			masked[i+1] = "****"
+		}
+	}
+	return masked
+}
+
+// containsString indique si value figure dans values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// dropTime retire l'horodatage des messages de la console (le fichier le conserve)
+func dropTime(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && attr.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return attr
+}
+
+// fatal journalise une erreur puis termine l'installateur en échec
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	closeLogging()
+	os.Exit(1)
+}
+
+// closeLogging ferme installer.log s'il a été ouvert par setupLogging
+var closeLogging = func() {}
+
+// logCommand enregistre une commande exécutée, son code de sortie et sa sortie
+func logCommand(name string, args []string, err error, output []byte) {
+	exitCode := 0
+	if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	attrs := []any{"command", strings.TrimSpace(name + " " + strings.Join(args, " ")), "exit_code", exitCode}
+	if err != nil && exitCode == -1 {
+		attrs = append(attrs, "error", err)
+	}
+	if out := strings.TrimSpace(string(output)); out != "" {
+		attrs = append(attrs, "output", out)
+	}
+	slog.Debug("Commande exécutée", attrs...)
+}
+
+// teeHandler transmet chaque message à plusieurs destinations, chacune
+// filtrant selon son propre niveau
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range t {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, handler := range t {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := handler.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, handler := range t {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, handler := range t {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
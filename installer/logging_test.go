@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestHasFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"--user-mode"}, true},
+		{[]string{"-user-mode"}, true},
+		{[]string{"--profile", "minimal", "--user-mode=true"}, true},
+		{[]string{"--user-mode=1"}, true},
+		{[]string{"--user-mode=false"}, false},
+		{[]string{"--user-mode=oui"}, false},
+		{[]string{"user-mode"}, false},
+		{[]string{"--user-modes"}, false},
+		{[]string{"--", "--user-mode"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := hasFlag(tt.args, "user-mode"); got != tt.want {
+			t.Errorf("hasFlag(%q) = %v, attendu %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestOpenInstallerLogInUserMode(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mode utilisateur réservé à Linux")
+	}
+	dir := useTestDirs(t)
+
+	file, path := openInstallerLog()
+	if file == nil {
+		t.Fatal("installer.log non ouvert")
+	}
+	file.Close()
+
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) || filepath.Base(path) != INSTALLER_LOG_FILE_NAME {
+		t.Errorf("installer.log ouvert dans %s, attendu sous %s", path, dir)
+	}
+	if !userMode {
+		t.Error("userMode modifié par l'ouverture du journal")
+	}
+}
+
+func TestParseLoggingFlags(t *testing.T) {
+	opts, rest, err := parseLoggingFlags([]string{"--verbose", "config", "--log-format=json", "set", "--", "--verbose", "--log-format", "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.Verbose || opts.Format != LOG_FORMAT_JSON {
+		t.Errorf("options %+v", opts)
+	}
+	want := []string{"config", "set", "--", "--verbose", "--log-format", "text"}
+	if strings.Join(rest, " ") != strings.Join(want, " ") {
+		t.Errorf("arguments restants %q, attendu %q", rest, want)
+	}
+}
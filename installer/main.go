@@ -1,10 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 )
 
 const (
@@ -20,41 +23,342 @@ const (
 )
 
 func main() {
-	fmt.Println("🚀 SmartSentry Agent Installer")
-	fmt.Println("Powered by OpenTelemetry Collector")
-	fmt.Printf("Target OS: %s, Architecture: %s\n\n", runtime.GOOS, runtime.GOARCH)
+	// --log-format et --verbose valent pour toutes les commandes, avant ou
+	// après leur nom
+	logging, args, err := parseLoggingFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	// Sans sous-commande, le programme effectue l'installation complète
+	command := "install"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	// Les commandes lancées hors root ciblent l'installation utilisateur si
+	// elle existe. install --user-mode n'est analysée qu'ensuite : le mode est
+	// résolu dès maintenant pour que installer.log aille dans le répertoire
+	// de l'utilisateur
+	userMode = detectUserMode() || (command == "install" && runtime.GOOS == "linux" && hasFlag(args, "user-mode"))
+
+	setupLogging(logging, command, args)
+	defer closeLogging()
+
+	switch command {
+	case "install":
+		runInstall(args)
+	case "ocb-manifest":
+		if err := runOCBManifest(args); err != nil {
+			fatal("Échec de la génération du manifeste OCB", "error", err)
+		}
+	case "doctor":
+		if err := runDoctor(args); err != nil {
+			fatal("Échec du diagnostic", "error", err)
+		}
+	case "status":
+		if err := runStatus(args); err != nil {
+			fatal("Échec de la lecture du statut", "error", err)
+		}
+	case "config":
+		if err := runConfig(args); err != nil {
+			fatal("Échec de la commande config", "error", err)
+		}
+	case "verify":
+		if err := runVerify(args); err != nil {
+			fatal("Échec de la vérification", "error", err)
+		}
+	case "export":
+		if err := runExport(args); err != nil {
+			fatal("Échec de l'export", "error", err)
+		}
+	case "package":
+		if err := runPackage(args); err != nil {
+			fatal("Échec de la construction des paquets", "error", err)
+		}
+	case "package-hook":
+		// Appelée par les scripts de maintenance des paquets .deb et .rpm
+		if err := runPackageHook(args); err != nil {
+			fatal("Échec du script de maintenance du paquet", "error", err)
+		}
+	default:
+		fatal("Commande inconnue (commandes disponibles : install, status, doctor, config, verify, export, package, ocb-manifest)", "command", command)
+	}
+}
+
+// installOptions regroupe les options de la commande install
+type installOptions struct {
+	// Politique appliquée si une configuration existe déjà (keep, replace, backup, merge)
+	ConfigPolicy string
+
+	// Adresse du Gateway (demandée interactivement si vide)
+	GatewayURL string
+
+	// Jeton d'authentification auprès du Gateway (stocké dans agent.env sous Linux)
+	Token string
+
+	// Modèle de configuration local (téléchargé depuis le dépôt si vide)
+	ConfigTemplate string
+
+	// Jeton d'enrôlement à usage unique ; l'endpoint, le jeton de l'agent, les
+	// autorités de certification (CAFile) et les tags sont fournis par le Gateway
+	Enroll string
+	CAFile string
+
+	// Autorités de certification vérifiant le Gateway pendant l'enrôlement, et
+	// acceptation explicite d'un enrôlement en clair (http://)
+	EnrollCA       string
+	InsecureEnroll bool
+
+	// Serveur OpAMP de supervision de flotte, auquel se connecte
+	// l'opamp-supervisor qui lance le collector, et son jeton
+	OpAMPEndpoint string
+	OpAMPToken    string
+
+	// Générer un nouvel identifiant d'agent au lieu de conserver l'existant
+	ResetIdentity bool
+
+	// Profil de collecte (minimal, standard, full)
+	Profile string
+
+	// Attributs de ressource statiques ajoutés à toutes les données (--tag cle=valeur)
+	Tags tagFlag
+
+	// Extensions de diagnostic optionnelles (health_check est toujours activée)
+	EnablePprof  bool
+	EnableZPages bool
+
+	// Délai d'attente de la disponibilité du collector après démarrage, et
+	// nombre de lignes de journal affichées en cas d'échec
+	ReadyTimeout time.Duration
+	JournalLines int
+
+	// Installation sans privilèges dans le répertoire personnel (unité systemd --user)
+	UserMode bool
+
+	// Système d'init qui supervise le collector (auto, systemd, openrc, sysv, none)
+	ServiceManager string
+
+	// Exécution du collector : binaire sur l'hôte ou image amont en conteneur,
+	// avec le moteur choisi (chemin absolu une fois résolu)
+	Runtime         string
+	ContainerEngine string
+
+	// Active le sandboxing systemd avancé de l'unité
+	Hardened bool
+
+	// Limites de ressources systemd, écrites dans un drop-in
+	MemoryMax   string
+	CPUQuota    string
+	LimitNOFILE string
+}
+
+// parseInstallOptions analyse les options de la commande install
+func parseInstallOptions(args []string) installOptions {
+	opts := installOptions{Tags: tagFlag{}}
+
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	fs.StringVar(&opts.ConfigPolicy, "config-policy", "", "action si une configuration existe déjà : keep, replace, backup ou merge (interactif si vide) ; merge conserve les valeurs existantes sauf --gateway, --token et --tag")
+	fs.StringVar(&opts.GatewayURL, "gateway", "", "URL du SmartSentry Gateway (demandée interactivement si absente)")
+	fs.StringVar(&opts.ConfigTemplate, "config-template", "", "modèle de configuration local (téléchargé depuis le dépôt si vide)")
+	fs.StringVar(&opts.Enroll, "enroll", "", "jeton d'enrôlement à usage unique fourni par le Gateway (<secret> ou <secret>@<hôte:port>), échangé en https")
+	fs.StringVar(&opts.EnrollCA, "enroll-ca", "", "fichier PEM des autorités de certification vérifiant le Gateway lors de l'enrôlement (autorités du système si vide)")
+	fs.BoolVar(&opts.InsecureEnroll, "insecure-enroll", false, "autoriser l'enrôlement auprès d'un Gateway en http:// (jeton transmis en clair)")
+	fs.StringVar(&opts.Token, "token", "", "jeton d'authentification envoyé au Gateway (en-tête Authorization: Bearer)")
+	fs.StringVar(&opts.OpAMPEndpoint, "opamp-endpoint", "", "serveur OpAMP de supervision de flotte (ex: wss://opamp.example:4320/v1/opamp) : le collector est lancé par l'opamp-supervisor, qui applique la configuration distante et remonte la configuration effective et la santé (Linux, --runtime binary)")
+	fs.StringVar(&opts.OpAMPToken, "opamp-token", "", "jeton envoyé au serveur OpAMP (en-tête Authorization: Bearer)")
+	fs.BoolVar(&opts.ResetIdentity, "reset-identity", false, "générer un nouvel identifiant d'agent (service.instance.id), par exemple sur une machine clonée ; enregistré seulement si la configuration est réécrite")
+	fs.StringVar(&opts.Profile, "profile", PROFILE_STANDARD, "profil de collecte : minimal, standard ou full (processus et journaux système)")
+	fs.Var(opts.Tags, "tag", "attribut de ressource ajouté aux données, au format clé=valeur (répétable)")
+	fs.BoolVar(&opts.EnablePprof, "enable-pprof", false, "activer l'extension pprof sur "+PPROF_ENDPOINT)
+	fs.BoolVar(&opts.EnableZPages, "enable-zpages", false, "activer l'extension zpages sur "+ZPAGES_ENDPOINT)
+	fs.DurationVar(&opts.ReadyTimeout, "ready-timeout", 60*time.Second, "délai d'attente de la disponibilité du collector après démarrage")
+	fs.IntVar(&opts.JournalLines, "journal-lines", 30, "nombre de lignes de journal affichées en cas d'échec du démarrage")
+	fs.BoolVar(&opts.UserMode, "user-mode", false, "installer pour l'utilisateur courant sans privilèges : ~/.local/bin, ~/.config/smartsentry-agent et unité systemd --user")
+	fs.StringVar(&opts.ServiceManager, "service-manager", SERVICE_MANAGER_AUTO, "système d'init sous Linux : auto, systemd, openrc, sysv ou none (fichiers uniquement)")
+	fs.StringVar(&opts.Runtime, "runtime", RUNTIME_BINARY, "exécution du collector sous Linux : binary (otelcol-contrib sur l'hôte) ou container (image amont via docker ou podman)")
+	fs.StringVar(&opts.ContainerEngine, "container-engine", CONTAINER_ENGINE_AUTO, "moteur de conteneurs avec --runtime container : auto, docker ou podman")
+	fs.BoolVar(&opts.Hardened, "hardened", false, "générer une unité systemd durcie (PrivateTmp, SystemCallFilter, CapabilityBoundingSet...)")
+	fs.StringVar(&opts.MemoryMax, "memory-max", "", "limite mémoire systemd du service (ex: 512M)")
+	fs.StringVar(&opts.CPUQuota, "cpu-quota", "", "quota CPU systemd du service (ex: 50%)")
+	fs.StringVar(&opts.LimitNOFILE, "limit-nofile", "", "nombre maximal de fichiers ouverts par le service (ex: 65536)")
+	fs.Parse(args)
+
+	if !validConfigPolicy(opts.ConfigPolicy) {
+		fatal("Valeur invalide pour --config-policy (keep, replace, backup ou merge)", "value", opts.ConfigPolicy)
+	}
+	if opts.UserMode {
+		if runtime.GOOS != "linux" {
+			fatal("--user-mode n'est disponible que sous Linux (unités systemd --user)")
+		}
+		if opts.ServiceManager != SERVICE_MANAGER_AUTO && opts.ServiceManager != SERVICE_MANAGER_SYSTEMD {
+			fatal("--user-mode nécessite systemd", "service_manager", opts.ServiceManager)
+		}
+		if opts.Hardened {
+			slog.Warn("--hardened ignoré en mode utilisateur : le sandboxing systemd est réservé aux unités système")
+		}
+		userMode = true
+	}
+	if opts.ResetIdentity && opts.ConfigPolicy == CONFIG_POLICY_KEEP {
+		fatal("--reset-identity nécessite de régénérer la configuration (--config-policy keep incompatible)")
+	}
+	if opts.Enroll != "" && opts.Token != "" {
+		fatal("--enroll et --token sont incompatibles : le jeton de l'agent est fourni par le Gateway")
+	}
+	if opts.Enroll != "" && opts.ConfigPolicy == CONFIG_POLICY_KEEP {
+		fatal("--enroll nécessite d'écrire la configuration (--config-policy keep incompatible)")
+	}
+	if opts.Enroll == "" && (opts.EnrollCA != "" || opts.InsecureEnroll) {
+		fatal("--enroll-ca et --insecure-enroll nécessitent --enroll")
+	}
+	if opts.OpAMPEndpoint != "" {
+		if err := validateOpAMPEndpoint(opts.OpAMPEndpoint); err != nil {
+			fatal("Valeur invalide pour --opamp-endpoint", "error", err)
+		}
+		// L'opamp-supervisor lance le binaire du collector sous le service Linux
+		if runtime.GOOS != "linux" || opts.Runtime != RUNTIME_BINARY {
+			fatal("--opamp-endpoint n'est disponible que sous Linux avec --runtime binary")
+		}
+	} else if opts.OpAMPToken != "" {
+		fatal("--opamp-token nécessite --opamp-endpoint")
+	}
+	if !validRuntime(opts.Runtime) {
+		fatal("Valeur invalide pour --runtime (binary ou container)", "value", opts.Runtime)
+	}
+	if opts.Runtime == RUNTIME_CONTAINER {
+		if runtime.GOOS != "linux" {
+			fatal("--runtime container n'est disponible que sous Linux")
+		}
+		if opts.UserMode {
+			fatal("--runtime container est incompatible avec --user-mode")
+		}
+		if !validContainerEngine(opts.ContainerEngine) {
+			fatal("Valeur invalide pour --container-engine (auto, docker ou podman)", "value", opts.ContainerEngine)
+		}
+		// Le conteneur est supervisé par une unité systemd
+		if opts.ServiceManager == SERVICE_MANAGER_AUTO {
+			opts.ServiceManager = SERVICE_MANAGER_SYSTEMD
+		} else if opts.ServiceManager != SERVICE_MANAGER_SYSTEMD {
+			fatal("--runtime container nécessite systemd", "service_manager", opts.ServiceManager)
+		}
+		if opts.Hardened {
+			slog.Warn("--hardened ignoré en conteneur : l'isolation est assurée par le moteur")
+		}
+	}
+	if !validServiceManager(opts.ServiceManager) {
+		fatal("Valeur invalide pour --service-manager (auto, systemd, openrc, sysv ou none)", "value", opts.ServiceManager)
+	}
+	if !validProfile(opts.Profile) {
+		fatal("Valeur invalide pour --profile (minimal, standard ou full)", "value", opts.Profile)
+	}
+
+	return opts
+}
+
+// tagFlag accumule les options --tag clé=valeur répétées
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	var pairs []string
+	for _, key := range sortedKeys(tagKeys(t)) {
+		pairs = append(pairs, key+"="+t[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlag) Set(value string) error {
+	key, val, err := parseAssignment(value)
+	if err != nil {
+		return err
+	}
+	t[key] = val
+	return nil
+}
+
+// runInstall déroule l'installation complète de l'agent
+func runInstall(args []string) {
+	opts := parseInstallOptions(args)
+
+	slog.Info("SmartSentry Agent Installer (OpenTelemetry Collector)", "os", runtime.GOOS, "arch", runtime.GOARCH, "collector_version", OTEL_VERSION)
 
 	// Vérifier les permissions administrateur
-	if !hasAdminPrivileges() {
-		log.Fatal("❌ Erreur : Ce programme doit être exécuté avec des privilèges administrateur (sudo sur Linux, Administrateur sur Windows)")
+	if userMode {
+		slog.Info("Mode utilisateur : installation dans le répertoire personnel, sans privilèges")
+	} else if !hasAdminPrivileges() {
+		fatal("Ce programme doit être exécuté avec des privilèges administrateur (sudo sur Linux, Administrateur sur Windows), ou avec --user-mode sous Linux")
 	}
 
-	// Étape 1 : Télécharger le binaire OpenTelemetry Collector
-	fmt.Println("📥 Téléchargement de l'OpenTelemetry Collector...")
-	if err := downloadOTelCollector(); err != nil {
-		log.Fatalf("❌ Erreur lors du téléchargement : %v", err)
+	// Enrôlement auprès du Gateway avant tout téléchargement ; le jeton est à
+	// usage unique : la politique de configuration est fixée avant de le consommer
+	if opts.Enroll != "" {
+		policy, err := resolveEnrollConfigPolicy(opts.ConfigPolicy)
+		if err != nil {
+			fatal("Enrôlement impossible", "error", err)
+		}
+		opts.ConfigPolicy = policy
+		if err := enrollAgent(&opts); err != nil {
+			fatal("Échec de l'enrôlement", "error", err)
+		}
 	}
-	fmt.Println("✅ OpenTelemetry Collector téléchargé")
+
+	// Étape 1 : Télécharger le binaire OpenTelemetry Collector (ou son image)
+	slog.Info("Téléchargement de l'OpenTelemetry Collector", "runtime", opts.Runtime)
+	if opts.Runtime == RUNTIME_CONTAINER {
+		engine, err := findContainerEngine(opts.ContainerEngine)
+		if err != nil {
+			fatal("Moteur de conteneurs indisponible", "error", err)
+		}
+		opts.ContainerEngine = engine
+		if err := pullCollectorImage(engine); err != nil {
+			fatal("Échec du téléchargement de l'image", "error", err)
+		}
+	} else if packagedCollector() {
+		slog.Warn("Collector fourni par le paquet : téléchargement ignoré, mettez-le à jour avec le gestionnaire de paquets (apt, dnf)",
+			"path", PACKAGE_COLLECTOR_PATH, "package", PACKAGE_NAME)
+	} else if err := downloadOTelCollector(); err != nil {
+		fatal("Échec du téléchargement", "error", err)
+	}
+	// L'opamp-supervisor suit la version du collector : il est mis à jour à
+	// chaque installation d'un agent supervisé
+	if opts.OpAMPEndpoint != "" || (opts.Runtime == RUNTIME_BINARY && supervised()) {
+		if err := downloadOpAMPSupervisor(); err != nil {
+			fatal("Échec du téléchargement de l'opamp-supervisor", "error", err)
+		}
+	}
+	if runtime.GOOS == "linux" {
+		if err := saveRuntimeChoice(opts.Runtime, opts.ContainerEngine); err != nil {
+			slog.Warn("Impossible de mémoriser le mode d'exécution", "error", err)
+		}
+	}
+	slog.Info("OpenTelemetry Collector téléchargé")
 
 	// Étape 2 : Télécharger et installer la configuration
-	fmt.Println("⚙️  Configuration de l'agent...")
-	if err := setupConfiguration(); err != nil {
-		log.Fatalf("❌ Erreur lors de la configuration : %v", err)
+	slog.Info("Configuration de l'agent")
+	if err := setupConfiguration(opts); err != nil {
+		fatal("Échec de la configuration", "error", err)
+	}
+	if runtime.GOOS == "linux" && opts.Runtime == RUNTIME_BINARY {
+		if err := setupOpAMPSupervisor(opts); err != nil {
+			fatal("Échec de la configuration de l'opamp-supervisor", "error", err)
+		}
 	}
-	fmt.Println("✅ Configuration installée")
+	slog.Info("Configuration installée")
 
 	// Étape 3 : Installer et démarrer le service
-	fmt.Println("🔧 Installation du service système...")
-	if err := installAndStartService(); err != nil {
-		log.Fatalf("❌ Erreur lors de l'installation du service : %v", err)
+	slog.Info("Installation du service système")
+	if err := installAndStartService(opts); err != nil {
+		fatal("Échec de l'installation du service", "error", err)
 	}
-	fmt.Println("✅ Service installé et démarré")
+	slog.Info("Service installé et démarré")
 
-	fmt.Println("\n🎉 Installation terminée avec succès !")
-	fmt.Printf("Le service '%s' est maintenant actif et collecte les métriques.\n", SERVICE_NAME)
+	// Enregistrer l'état des fichiers installés pour la commande verify
+	updateInstallManifest()
+
+	slog.Info("Installation terminée avec succès : le service collecte les métriques", "service", SERVICE_NAME)
 
 	// Instructions spécifiques à l'OS pour vérifier le service
-	printServiceInstructions()
+	logServiceInstructions()
 }
 
 // hasAdminPrivileges vérifie si le programme s'exécute avec les privilèges administrateur
@@ -73,38 +377,74 @@ func hasAdminPrivileges() bool {
 	}
 }
 
-// printServiceInstructions affiche les commandes pour gérer le service selon l'OS
-func printServiceInstructions() {
-	fmt.Println("\n📋 Commandes utiles pour gérer le service :")
+// logServiceInstructions journalise les commandes utiles pour gérer le
+// service installé, selon l'OS et le gestionnaire de service
+func logServiceInstructions() {
+	for _, instruction := range serviceInstructions() {
+		slog.Info("Commande utile", "action", instruction[0], "command", instruction[1])
+	}
+}
 
+// serviceInstructions retourne les couples (action, commande) de gestion du service
+func serviceInstructions() [][2]string {
 	switch runtime.GOOS {
 	case "linux":
-		fmt.Printf("  • Statut      : sudo systemctl status %s\n", SERVICE_NAME)
-		fmt.Printf("  • Arrêter     : sudo systemctl stop %s\n", SERVICE_NAME)
-		fmt.Printf("  • Redémarrer  : sudo systemctl restart %s\n", SERVICE_NAME)
-		fmt.Printf("  • Logs        : sudo journalctl -u %s -f\n", SERVICE_NAME)
-		fmt.Printf("  • Désinstaller: sudo systemctl stop %s && sudo systemctl disable %s\n", SERVICE_NAME, SERVICE_NAME)
+		switch installedServiceManagerName() {
+		case SERVICE_MANAGER_OPENRC:
+			return [][2]string{
+				{"statut", "sudo rc-service " + SERVICE_NAME + " status"},
+				{"redémarrer", "sudo rc-service " + SERVICE_NAME + " restart"},
+				{"logs", "sudo tail -f " + COLLECTOR_LOG_FILE},
+			}
+		case SERVICE_MANAGER_SYSV:
+			return [][2]string{
+				{"statut", "sudo " + initScriptPath() + " status"},
+				{"redémarrer", "sudo " + initScriptPath() + " restart"},
+				{"logs", "sudo tail -f " + COLLECTOR_LOG_FILE},
+			}
+		case SERVICE_MANAGER_NONE:
+			// Aucun service installé (--service-manager none)
+			return nil
+		}
+		if userMode {
+			return [][2]string{
+				{"statut", "systemctl --user status " + SERVICE_NAME},
+				{"arrêter", "systemctl --user stop " + SERVICE_NAME},
+				{"redémarrer", "systemctl --user restart " + SERVICE_NAME},
+				{"logs", "journalctl --user-unit " + SERVICE_NAME + " -f"},
+				{"désinstaller", "systemctl --user disable --now " + SERVICE_NAME},
+			}
+		}
+		return [][2]string{
+			{"statut", "sudo systemctl status " + SERVICE_NAME},
+			{"arrêter", "sudo systemctl stop " + SERVICE_NAME},
+			{"redémarrer", "sudo systemctl restart " + SERVICE_NAME},
+			{"logs", "sudo journalctl -u " + SERVICE_NAME + " -f"},
+			{"désinstaller", "sudo systemctl stop " + SERVICE_NAME + " && sudo systemctl disable " + SERVICE_NAME},
+		}
 	case "windows":
-		fmt.Printf("  • Statut      : sc query \"%s\"\n", SERVICE_NAME)
-		fmt.Printf("  • Arrêter     : sc stop \"%s\"\n", SERVICE_NAME)
-		fmt.Printf("  • Redémarrer  : sc stop \"%s\" && sc start \"%s\"\n", SERVICE_NAME, SERVICE_NAME)
-		fmt.Printf("  • Logs        : Check Event Viewer > Windows Logs > Application\n")
-		fmt.Printf("  • Désinstaller: sc stop \"%s\" && sc delete \"%s\"\n", SERVICE_NAME, SERVICE_NAME)
+		return [][2]string{
+			{"statut", fmt.Sprintf("sc query %q", SERVICE_NAME)},
+			{"arrêter", fmt.Sprintf("sc stop %q", SERVICE_NAME)},
+			{"redémarrer", fmt.Sprintf("sc stop %q && sc start %q", SERVICE_NAME, SERVICE_NAME)},
+			{"logs", "Observateur d'événements > Journaux Windows > Application"},
+			{"désinstaller", fmt.Sprintf("sc stop %q && sc delete %q", SERVICE_NAME, SERVICE_NAME)},
+		}
 	}
+	return nil
 }
 
 // installAndStartService installe et démarre le service selon l'OS
-func installAndStartService() error {
+func installAndStartService(opts installOptions) error {
 	switch runtime.GOOS {
 	case "linux":
-		return installLinuxService()
+		return installLinuxService(opts)
 	case "windows":
 		return installWindowsService()
 	case "darwin":
 		// Sur macOS, on pourrait utiliser launchd, mais pour simplifier
 		// on affiche un message pour l'instant
-		fmt.Println("⚠️  Sur macOS, veuillez démarrer manuellement l'agent :")
-		fmt.Println("sudo /usr/local/bin/otelcol-contrib --config=/etc/smartsentry-agent/config.yaml")
+		slog.Warn("Sur macOS, démarrez manuellement l'agent", "command", "sudo /usr/local/bin/otelcol-contrib --config=/etc/smartsentry-agent/config.yaml")
 		return nil
 	default:
 		return fmt.Errorf("installation de service non supportée sur %s", runtime.GOOS)
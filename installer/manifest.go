@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	// Manifeste des fichiers installés, dans le répertoire d'état
+	INSTALL_MANIFEST_NAME = "install-manifest.json"
+
+	// Copies des fichiers gérés par l'installateur, utilisées par verify --repair
+	MANAGED_SOURCES_DIR = "sources"
+
+	// Rôles des fichiers enregistrés dans le manifeste
+	ROLE_BINARY     = "binary"
+	ROLE_SUPERVISOR = "supervisor" // binaire de l'opamp-supervisor
+	ROLE_CONFIG     = "config"
+	ROLE_ENV        = "env"
+	ROLE_UNIT       = "unit"
+	ROLE_DROPIN     = "dropin"
+	ROLE_INIT       = "init" // script OpenRC ou SysV
+	ROLE_CA         = "ca"   // autorités de certification du Gateway (enrôlement)
+)
+
+// manifestEntry décrit l'état attendu d'un fichier installé
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Role   string `json:"role"`
+	SHA256 string `json:"sha256"`
+	Mode   string `json:"mode"`
+	UID    *int   `json:"uid,omitempty"`
+	GID    *int   `json:"gid,omitempty"`
+
+	// Origine du contenu : URL de téléchargement pour les binaires, copie dans
+	// le répertoire d'état pour les autres fichiers
+	Source string `json:"source"`
+}
+
+// installManifest est le manifeste enregistré après chaque modification
+type installManifest struct {
+	OTelVersion string          `json:"otel_version"`
+	RecordedAt  time.Time       `json:"recorded_at"`
+	Files       []manifestEntry `json:"files"`
+}
+
+// managedFiles retourne les fichiers gérés par l'installateur et leur rôle
+func managedFiles() ([]manifestEntry, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	files := []manifestEntry{{Path: configPath, Role: ROLE_CONFIG}}
+	if caPath, err := getGatewayCAPath(); err == nil {
+		files = append(files, manifestEntry{Path: caPath, Role: ROLE_CA})
+	}
+	// En conteneur, le collector est fourni par l'image
+	if mode, _ := installedRuntime(); mode != RUNTIME_CONTAINER {
+		files = append([]manifestEntry{{Path: getBinaryPath(), Role: ROLE_BINARY}}, files...)
+	}
+	if supervised() {
+		supervisorConfigPath, err := getSupervisorConfigPath()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			manifestEntry{Path: getSupervisorPath(), Role: ROLE_SUPERVISOR},
+			manifestEntry{Path: supervisorConfigPath, Role: ROLE_CONFIG},
+		)
+	}
+
+	if runtime.GOOS == "linux" {
+		envPath, err := getEnvFilePath()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			manifestEntry{Path: envPath, Role: ROLE_ENV},
+			manifestEntry{Path: systemdUnitPath(), Role: ROLE_UNIT},
+			manifestEntry{Path: filepath.Join(systemdDropInDir(), SYSTEMD_DROPIN_NAME), Role: ROLE_DROPIN},
+			manifestEntry{Path: initScriptPath(), Role: ROLE_INIT},
+		)
+	}
+	return files, nil
+}
+
+// getManifestPath retourne le chemin du manifeste d'installation
+func getManifestPath() (string, error) {
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, INSTALL_MANIFEST_NAME), nil
+}
+
+// recordInstallManifest enregistre l'état actuel des fichiers gérés (empreinte,
+// mode, propriétaire) et conserve une copie de chacun sauf des binaires
+func recordInstallManifest() error {
+	files, err := managedFiles()
+	if err != nil {
+		return err
+	}
+	sourcesDir, err := ensureStateDirectory(MANAGED_SOURCES_DIR)
+	if err != nil {
+		return err
+	}
+
+	manifest := installManifest{OTelVersion: OTEL_VERSION, RecordedAt: time.Now().UTC()}
+	for _, entry := range files {
+		info, err := os.Stat(entry.Path)
+		if os.IsNotExist(err) {
+			continue // ex: pas de drop-in sans limites de ressources
+		}
+		if err != nil {
+			return err
+		}
+
+		entry.SHA256, err = fileSHA256(entry.Path)
+		if err != nil {
+			return err
+		}
+		entry.Mode = formatFileMode(info.Mode())
+		if uid, gid, ok := fileOwner(info); ok {
+			entry.UID, entry.GID = &uid, &gid
+		}
+
+		switch entry.Role {
+		case ROLE_BINARY:
+			entry.Source, _ = getOTelDownloadInfo()
+		case ROLE_SUPERVISOR:
+			entry.Source = getSupervisorDownloadURL(runtime.GOOS, runtime.GOARCH)
+		default:
+			entry.Source = filepath.Join(sourcesDir, filepath.Base(entry.Path))
+			content, err := os.ReadFile(entry.Path)
+			if err != nil {
+				return err
+			}
+			// Les copies de agent.env contiennent des secrets
+			if err := writeBytesAtomic(entry.Source, content, 0600); err != nil {
+				return err
+			}
+		}
+
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestPath, err := getManifestPath()
+	if err != nil {
+		return err
+	}
+	return writeBytesAtomic(manifestPath, append(data, '\n'), 0600)
+}
+
+// updateInstallManifest enregistre le manifeste en signalant un échec sans
+// interrompre la commande (le manifeste ne sert qu'à verify)
+func updateInstallManifest() {
+	if err := recordInstallManifest(); err != nil {
+		slog.Warn("Impossible d'enregistrer le manifeste d'installation", "error", err)
+	}
+}
+
+// readInstallManifest lit le manifeste d'installation
+func readInstallManifest() (*installManifest, error) {
+	manifestPath, err := getManifestPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var manifest installManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("manifeste %s illisible : %w", manifestPath, err)
+	}
+	return &manifest, nil
+}
+
+// fileSHA256 calcule l'empreinte SHA-256 d'un fichier
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// formatFileMode retourne les permissions au format octal (ex: 0644)
+func formatFileMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// parseFileMode analyse des permissions au format octal
+func parseFileMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("mode invalide : %s", mode)
+	}
+	return os.FileMode(value), nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Modules Go des composants du cœur du Collector et de la distribution contrib
+	OTEL_CORE_MODULE    = "go.opentelemetry.io/collector"
+	OTEL_CONTRIB_MODULE = "github.com/open-telemetry/opentelemetry-collector-contrib"
+)
+
+// ocbComponentKinds liste les sections de la configuration qui déclarent des
+// composants, dans l'ordre attendu par l'OpenTelemetry Collector Builder
+var ocbComponentKinds = []string{"extensions", "receivers", "processors", "exporters", "connectors"}
+
+// ocbCoreComponents recense les composants livrés par le module du cœur
+// (go.opentelemetry.io/collector) plutôt que par contrib
+var ocbCoreComponents = map[string]map[string]bool{
+	"receivers":  {"otlp": true, "nop": true},
+	"processors": {"batch": true, "memory_limiter": true},
+	"exporters":  {"otlp": true, "otlphttp": true, "debug": true, "nop": true},
+	"extensions": {"zpages": true, "memory_limiter": true},
+	"connectors": {"forward": true},
+}
+
+// ocbModuleOverrides contient les composants dont le chemin de module ne suit
+// pas la convention <kind>/<type><kind>
+var ocbModuleOverrides = map[string]map[string]string{
+	"extensions": {
+		"file_storage": OTEL_CONTRIB_MODULE + "/extension/storage/filestorage",
+		"db_storage":   OTEL_CONTRIB_MODULE + "/extension/storage/dbstorage",
+	},
+}
+
+// ocbManifest représente le fichier builder-config.yaml consommé par OCB
+type ocbManifest struct {
+	Dist       ocbDist     `yaml:"dist"`
+	Extensions []ocbModule `yaml:"extensions,omitempty"`
+	Receivers  []ocbModule `yaml:"receivers,omitempty"`
+	Processors []ocbModule `yaml:"processors,omitempty"`
+	Exporters  []ocbModule `yaml:"exporters,omitempty"`
+	Connectors []ocbModule `yaml:"connectors,omitempty"`
+}
+
+// ocbDist décrit la distribution produite par OCB
+type ocbDist struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	OutputPath  string `yaml:"output_path"`
+	Version     string `yaml:"version"`
+}
+
+// ocbModule référence un module Go de composant avec sa version
+type ocbModule struct {
+	GoMod string `yaml:"gomod"`
+}
+
+// runOCBManifest implémente la commande ocb-manifest : elle lit une configuration
+// rendue et écrit le manifeste OCB correspondant
+func runOCBManifest(args []string) error {
+	fs := flag.NewFlagSet("ocb-manifest", flag.ExitOnError)
+	configPath := fs.String("config", "", "chemin de la configuration du collector (config.yaml)")
+	version := fs.String("otel-version", OTEL_VERSION, "version de l'OpenTelemetry Collector à construire")
+	output := fs.String("output", "builder-config.yaml", "fichier manifeste à écrire (- pour la sortie standard)")
+	name := fs.String("name", "smartsentry-otelcol", "nom du binaire produit par OCB")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return fmt.Errorf("l'option --config est obligatoire")
+	}
+
+	content, err := os.ReadFile(*configPath)
+	if err != nil {
+		return fmt.Errorf("impossible de lire %s : %w", *configPath, err)
+	}
+
+	manifest, err := buildOCBManifest(content, *name, strings.TrimPrefix(*version, "v"))
+	if err != nil {
+		return err
+	}
+
+	data, err := marshalYAML(manifest)
+	if err != nil {
+		return fmt.Errorf("impossible de sérialiser le manifeste : %w", err)
+	}
+
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", *output, err)
+	}
+
+	slog.Info("Manifeste OCB écrit", "path", *output)
+	return nil
+}
+
+// buildOCBManifest construit le manifeste OCB listant exactement les composants
+// utilisés par la configuration : ceux des pipelines de service.pipelines et
+// les extensions de service.extensions. Les composants déclarés mais non
+// utilisés n'alourdissent pas le binaire.
+func buildOCBManifest(config []byte, name, version string) (*ocbManifest, error) {
+	var parsed struct {
+		Extensions map[string]interface{} `yaml:"extensions"`
+		Receivers  map[string]interface{} `yaml:"receivers"`
+		Processors map[string]interface{} `yaml:"processors"`
+		Exporters  map[string]interface{} `yaml:"exporters"`
+		Connectors map[string]interface{} `yaml:"connectors"`
+		Service    struct {
+			Extensions []string `yaml:"extensions"`
+			Pipelines  map[string]struct {
+				Receivers  []string `yaml:"receivers"`
+				Processors []string `yaml:"processors"`
+				Exporters  []string `yaml:"exporters"`
+			} `yaml:"pipelines"`
+		} `yaml:"service"`
+	}
+	if err := yaml.Unmarshal(config, &parsed); err != nil {
+		return nil, fmt.Errorf("configuration YAML invalide : %w", err)
+	}
+	declared := map[string]map[string]interface{}{
+		"extensions": parsed.Extensions,
+		"receivers":  parsed.Receivers,
+		"processors": parsed.Processors,
+		"exporters":  parsed.Exporters,
+		"connectors": parsed.Connectors,
+	}
+
+	// Types utilisés par famille : une même famille peut être utilisée sous
+	// plusieurs identifiants (ex: otlphttp/primary)
+	used := map[string]map[string]bool{}
+	use := func(kind, id, where string) error {
+		if _, ok := declared[kind][id]; !ok {
+			return fmt.Errorf("%s référence %s, absent de la section %s", where, id, kind)
+		}
+		if used[kind] == nil {
+			used[kind] = map[string]bool{}
+		}
+		used[kind][componentType(id)] = true
+		return nil
+	}
+	// Un connector sert d'exporter à un pipeline et de receiver à un autre
+	useEndpoint := func(kind, id, where string) error {
+		if _, ok := parsed.Connectors[id]; ok {
+			kind = "connectors"
+		}
+		return use(kind, id, where)
+	}
+
+	for _, id := range parsed.Service.Extensions {
+		if err := use("extensions", id, "service.extensions"); err != nil {
+			return nil, err
+		}
+	}
+	for pipeline, components := range parsed.Service.Pipelines {
+		where := "le pipeline " + pipeline
+		for _, id := range components.Receivers {
+			if err := useEndpoint("receivers", id, where); err != nil {
+				return nil, err
+			}
+		}
+		for _, id := range components.Processors {
+			if err := use("processors", id, where); err != nil {
+				return nil, err
+			}
+		}
+		for _, id := range components.Exporters {
+			if err := useEndpoint("exporters", id, where); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	manifest := &ocbManifest{
+		Dist: ocbDist{
+			Name:        name,
+			Description: "SmartSentry Agent - OpenTelemetry Collector minimal",
+			OutputPath:  "./_build",
+			Version:     version,
+		},
+	}
+
+	for _, kind := range ocbComponentKinds {
+		var modules []ocbModule
+		for componentType := range used[kind] {
+			modules = append(modules, ocbModule{
+				GoMod: componentModule(kind, componentType) + " v" + version,
+			})
+		}
+		sort.Slice(modules, func(i, j int) bool { return modules[i].GoMod < modules[j].GoMod })
+
+		switch kind {
+		case "extensions":
+			manifest.Extensions = modules
+		case "receivers":
+			manifest.Receivers = modules
+		case "processors":
+			manifest.Processors = modules
+		case "exporters":
+			manifest.Exporters = modules
+		case "connectors":
+			manifest.Connectors = modules
+		}
+	}
+
+	if len(manifest.Receivers) == 0 || len(manifest.Exporters) == 0 {
+		return nil, fmt.Errorf("les pipelines de la configuration doivent utiliser au moins un receiver et un exporter")
+	}
+
+	return manifest, nil
+}
+
+// marshalYAML sérialise une valeur en YAML avec une indentation de 2 espaces,
+// comme les configurations livrées dans configs/
+func marshalYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// componentType extrait le type d'un identifiant de composant "type[/nom]"
+func componentType(id string) string {
+	if i := strings.Index(id, "/"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// componentModule retourne le chemin du module Go qui fournit un composant
+func componentModule(kind, componentType string) string {
+	if module, ok := ocbModuleOverrides[kind][componentType]; ok {
+		return module
+	}
+
+	// "receivers" -> "receiver", et le suffixe du package suit la même règle
+	singular := strings.TrimSuffix(kind, "s")
+	pkg := strings.ReplaceAll(componentType, "_", "") + singular
+
+	if ocbCoreComponents[kind][componentType] {
+		return fmt.Sprintf("%s/%s/%s", OTEL_CORE_MODULE, singular, pkg)
+	}
+	return fmt.Sprintf("%s/%s/%s", OTEL_CONTRIB_MODULE, singular, pkg)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ocbTestConfig déclare des composants utilisés par les pipelines et d'autres
+// qui ne le sont pas (prometheus, filter, debug, pprof, count)
+const ocbTestConfig = `
+extensions:
+  health_check: {}
+  pprof: {}
+receivers:
+  otlp: {}
+  hostmetrics: {}
+  prometheus: {}
+processors:
+  batch: {}
+  filter: {}
+exporters:
+  otlphttp/primary: {}
+  otlphttp/backup: {}
+  debug: {}
+connectors:
+  forward: {}
+  count: {}
+service:
+  extensions: [health_check]
+  pipelines:
+    metrics/in:
+      receivers: [hostmetrics, otlp]
+      processors: [batch]
+      exporters: [forward]
+    metrics/out:
+      receivers: [forward]
+      exporters: [otlphttp/primary, otlphttp/backup]
+`
+
+// ocbModulePaths retourne les modules sans leur version
+func ocbModulePaths(modules []ocbModule) []string {
+	var paths []string
+	for _, module := range modules {
+		paths = append(paths, strings.Fields(module.GoMod)[0])
+	}
+	return paths
+}
+
+func TestBuildOCBManifestKeepsPipelineComponents(t *testing.T) {
+	manifest, err := buildOCBManifest([]byte(ocbTestConfig), "smartsentry-otelcol", "0.128.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		kind    string
+		modules []ocbModule
+		want    []string
+	}{
+		{"extensions", manifest.Extensions, []string{OTEL_CONTRIB_MODULE + "/extension/healthcheckextension"}},
+		{"receivers", manifest.Receivers, []string{OTEL_CONTRIB_MODULE + "/receiver/hostmetricsreceiver", OTEL_CORE_MODULE + "/receiver/otlpreceiver"}},
+		{"processors", manifest.Processors, []string{OTEL_CORE_MODULE + "/processor/batchprocessor"}},
+		{"exporters", manifest.Exporters, []string{OTEL_CORE_MODULE + "/exporter/otlphttpexporter"}},
+		{"connectors", manifest.Connectors, []string{OTEL_CORE_MODULE + "/connector/forwardconnector"}},
+	}
+	for _, tt := range tests {
+		if got := ocbModulePaths(tt.modules); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s : %v, attendu %v", tt.kind, got, tt.want)
+		}
+	}
+	if got := manifest.Receivers[0].GoMod; !strings.HasSuffix(got, " v0.128.0") {
+		t.Errorf("version absente : %s", got)
+	}
+}
+
+func TestBuildOCBManifestRejectsUndeclaredComponent(t *testing.T) {
+	config := strings.Replace(ocbTestConfig, "processors: [batch]", "processors: [batch, memory_limiter]", 1)
+	if _, err := buildOCBManifest([]byte(config), "smartsentry-otelcol", "0.128.0"); err == nil || !strings.Contains(err.Error(), "memory_limiter") {
+		t.Errorf("erreur %v", err)
+	}
+}
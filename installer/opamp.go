@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ENV_OPAMP_TOKEN est la variable de agent.env contenant le jeton du serveur OpAMP
+	ENV_OPAMP_TOKEN = "SMARTSENTRY_OPAMP_TOKEN"
+
+	// Ancien fichier du répertoire d'état contenant l'identifiant annoncé au
+	// serveur OpAMP, remplacé par l'identifiant de l'agent (AGENT_ID_FILE)
+	OPAMP_INSTANCE_UID_FILE = "opamp-instance-uid"
+
+	// Configuration de l'opamp-supervisor, dans le répertoire de configuration
+	SUPERVISOR_CONFIG_FILE = "supervisor.yaml"
+
+	// Répertoire de travail de l'opamp-supervisor dans le répertoire d'état,
+	// accessible en écriture au service : configuration distante reçue,
+	// configuration effective du collector et identifiant annoncé au serveur
+	SUPERVISOR_STORAGE_DIR = "opamp-supervisor"
+	SUPERVISOR_STATE_FILE  = "persistent_state.yaml"
+
+	// Concaténé à la clé de la poignée de main WebSocket pour calculer
+	// Sec-WebSocket-Accept (RFC 6455)
+	WEBSOCKET_ACCEPT_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// supervisorConfig représente le fichier supervisor.yaml lu par l'opamp-supervisor
+type supervisorConfig struct {
+	Server struct {
+		Endpoint string            `yaml:"endpoint"`
+		Headers  map[string]string `yaml:"headers,omitempty"`
+		TLS      *struct {
+			CAFile string `yaml:"ca_file"`
+		} `yaml:"tls,omitempty"`
+	} `yaml:"server"`
+	Capabilities struct {
+		AcceptsRemoteConfig    bool `yaml:"accepts_remote_config"`
+		ReportsEffectiveConfig bool `yaml:"reports_effective_config"`
+		ReportsRemoteConfig    bool `yaml:"reports_remote_config"`
+		ReportsHealth          bool `yaml:"reports_health"`
+	} `yaml:"capabilities"`
+	Agent struct {
+		Executable      string   `yaml:"executable"`
+		ConfigFiles     []string `yaml:"config_files"`
+		PassthroughLogs bool     `yaml:"passthrough_logs"`
+	} `yaml:"agent"`
+	Storage struct {
+		Directory string `yaml:"directory"`
+	} `yaml:"storage"`
+}
+
+// supervisorParams regroupe les paramètres de rendu de supervisor.yaml
+type supervisorParams struct {
+	Endpoint string
+
+	// Valeur de l'en-tête Authorization (référence à agent.env), vide sans jeton
+	Token  string
+	CAFile string
+
+	Executable string
+	ConfigPath string
+	StorageDir string
+}
+
+// validateOpAMPEndpoint vérifie l'adresse du serveur OpAMP : WebSocket
+// (ws, wss) ou HTTP (http, https, interrogation périodique)
+func validateOpAMPEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("adresse OpAMP invalide : %s", endpoint)
+	}
+	switch parsed.Scheme {
+	case "ws", "wss", "http", "https":
+		return nil
+	default:
+		return fmt.Errorf("schéma OpAMP non supporté : %s (ws, wss, http ou https)", parsed.Scheme)
+	}
+}
+
+// renderSupervisorConfig produit supervisor.yaml : l'opamp-supervisor se
+// connecte au serveur, lance le collector avec config.yaml complétée de la
+// configuration distante reçue, puis remonte la configuration effective et
+// l'état de santé. Il injecte lui-même l'extension opamp qui le relie au
+// collector.
+func renderSupervisorConfig(params supervisorParams) ([]byte, error) {
+	if err := validateOpAMPEndpoint(params.Endpoint); err != nil {
+		return nil, err
+	}
+
+	var config supervisorConfig
+	config.Server.Endpoint = params.Endpoint
+	if params.Token != "" {
+		config.Server.Headers = map[string]string{"Authorization": "Bearer " + params.Token}
+	}
+	if params.CAFile != "" {
+		config.Server.TLS = &struct {
+			CAFile string `yaml:"ca_file"`
+		}{CAFile: params.CAFile}
+	}
+
+	config.Capabilities.AcceptsRemoteConfig = true
+	config.Capabilities.ReportsEffectiveConfig = true
+	config.Capabilities.ReportsRemoteConfig = true
+	config.Capabilities.ReportsHealth = true
+
+	// Les fichiers suivants l'emportent sur les précédents : la configuration
+	// distante sur config.yaml, et l'extension du supervisor sur les deux
+	config.Agent.Executable = params.Executable
+	config.Agent.ConfigFiles = []string{params.ConfigPath, "$REMOTE_CONFIG", "$OPAMP_EXTENSION_CONFIG"}
+	// Journaux du collector relayés sur la sortie du supervisor (journal du service)
+	config.Agent.PassthroughLogs = true
+
+	config.Storage.Directory = params.StorageDir
+
+	return marshalYAML(config)
+}
+
+// readSupervisorConfig lit supervisor.yaml
+func readSupervisorConfig(path string) (*supervisorConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config supervisorConfig
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("%s illisible : %w", path, err)
+	}
+	return &config, nil
+}
+
+// describeOpAMP résume ce que fait l'intégration OpAMP, pour la sortie de
+// l'installation et de doctor
+func describeOpAMP() string {
+	return "collector lancé par l'opamp-supervisor : configuration distante appliquée par-dessus config.yaml, configuration effective et état de santé remontés au serveur"
+}
+
+// getSupervisorPath retourne l'emplacement du binaire de l'opamp-supervisor
+func getSupervisorPath() string {
+	if userMode {
+		return userHomePath(".local", "bin", "opampsupervisor")
+	}
+	return "/usr/local/bin/opampsupervisor"
+}
+
+// getSupervisorConfigPath retourne le chemin de supervisor.yaml
+func getSupervisorConfigPath() (string, error) {
+	configDir, err := getConfigDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, SUPERVISOR_CONFIG_FILE), nil
+}
+
+// getSupervisorStorageDirectory retourne le répertoire de travail de l'opamp-supervisor
+func getSupervisorStorageDirectory() (string, error) {
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, SUPERVISOR_STORAGE_DIR), nil
+}
+
+// supervised indique si le collector est lancé par l'opamp-supervisor
+// (supervisor.yaml écrit par une installation avec --opamp-endpoint)
+func supervised() bool {
+	path, err := getSupervisorConfigPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// getSupervisorDownloadURL retourne l'URL du binaire de l'opamp-supervisor,
+// publié dans la même version que le collector
+func getSupervisorDownloadURL(goos, goarch string) string {
+	return fmt.Sprintf("https://github.com/open-telemetry/opentelemetry-collector-releases/releases/download/cmd%%2Fopampsupervisor%%2Fv%s/opampsupervisor_%s_%s_%s",
+		OTEL_VERSION, OTEL_VERSION, goos, goarch)
+}
+
+// downloadOpAMPSupervisor télécharge et installe le binaire de l'opamp-supervisor
+func downloadOpAMPSupervisor() error {
+	workDir, err := os.MkdirTemp("", "smartsentry-installer-")
+	if err != nil {
+		return fmt.Errorf("impossible de créer le répertoire temporaire : %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	downloadURL := getSupervisorDownloadURL(runtime.GOOS, runtime.GOARCH)
+	slog.Info("Téléchargement de l'opamp-supervisor", "url", downloadURL)
+
+	// Le binaire est publié tel quel, sans archive
+	binaryPath := filepath.Join(workDir, "opampsupervisor")
+	if err := downloadFile(downloadURL, binaryPath); err != nil {
+		return fmt.Errorf("échec du téléchargement de l'opamp-supervisor : %w", err)
+	}
+	if err := os.Chmod(binaryPath, 0755); err != nil {
+		return err
+	}
+
+	destPath := getSupervisorPath()
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	slog.Info("Installation de l'opamp-supervisor", "path", destPath)
+	return copyFile(binaryPath, destPath)
+}
+
+// setupOpAMPSupervisor écrit supervisor.yaml (--opamp-endpoint) et prépare le
+// répertoire de travail de l'opamp-supervisor. L'identifiant annoncé au
+// serveur est celui de l'agent : il est inscrit dans l'état du supervisor,
+// qui sinon en générerait un nouveau.
+func setupOpAMPSupervisor(opts installOptions) error {
+	configPath, err := getSupervisorConfigPath()
+	if err != nil {
+		return err
+	}
+	storageDir, err := getSupervisorStorageDirectory()
+	if err != nil {
+		return err
+	}
+
+	if opts.OpAMPEndpoint != "" {
+		collectorConfigPath, err := getConfigPath()
+		if err != nil {
+			return err
+		}
+		params := supervisorParams{
+			Endpoint:   opts.OpAMPEndpoint,
+			CAFile:     opts.CAFile,
+			Executable: getBinaryPath(),
+			ConfigPath: collectorConfigPath,
+			StorageDir: storageDir,
+		}
+		// Le jeton reste dans agent.env, chargé par le service
+		if opts.OpAMPToken != "" {
+			params.Token = envReference(ENV_OPAMP_TOKEN)
+		}
+		content, err := renderSupervisorConfig(params)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+			return err
+		}
+		if err := writeBytesAtomic(configPath, content, 0644); err != nil {
+			return fmt.Errorf("impossible d'écrire %s : %w", configPath, err)
+		}
+		slog.Info("Serveur OpAMP", "endpoint", opts.OpAMPEndpoint, "config", configPath, "scope", describeOpAMP())
+	} else if !supervised() {
+		return nil
+	}
+
+	if err := os.MkdirAll(storageDir, 0750); err != nil {
+		return fmt.Errorf("impossible de créer %s : %w", storageDir, err)
+	}
+
+	// Identifiant non encore enregistré (configuration existante conservée) :
+	// l'opamp-supervisor conserve le sien
+	agentID, stored, err := loadAgentID()
+	if err != nil || !stored {
+		return err
+	}
+	statePath := filepath.Join(storageDir, SUPERVISOR_STATE_FILE)
+	state := []byte("instance_id: " + agentID + "\n")
+	if current, err := os.ReadFile(statePath); err == nil && bytes.Equal(current, state) {
+		return nil
+	}
+	if err := writeBytesAtomicWithoutBackup(statePath, state, 0600); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", statePath, err)
+	}
+	return nil
+}
+
+// secureSupervisorStorage confie le répertoire de travail de l'opamp-supervisor
+// à l'utilisateur de service ; le répertoire d'état devient traversable (0711)
+// sans que ses autres sous-répertoires, privés, deviennent lisibles
+func secureSupervisorStorage() error {
+	if userMode || !supervised() {
+		return nil
+	}
+	storageDir, err := getSupervisorStorageDirectory()
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(filepath.Dir(storageDir), 0711); err != nil {
+		return err
+	}
+	return runSystemCommand("chown", "-R", "smartsentry:smartsentry", storageDir)
+}
+
+// removeOpAMPExtension retire l'extension opamp d'une configuration écrite par
+// une version antérieure (rapport seul) : l'opamp-supervisor injecte la sienne
+func removeOpAMPExtension(root *yaml.Node) {
+	if extensions := yamlGet(root, "extensions"); extensions != nil {
+		yamlDelete(extensions, "opamp")
+	}
+	if service := yamlGet(root, "service"); service != nil {
+		yamlRemoveFromSequence(service, "extensions", "opamp")
+	}
+}
+
+// probeOpAMP ouvre une session OpAMP comme le ferait l'opamp-supervisor :
+// poignée de main WebSocket (ws, wss) ou message AgentToServer réduit à
+// l'identifiant de l'agent (http, https), avec l'en-tête Authorization donné
+func probeOpAMP(client *http.Client, endpoint, authorization string, instanceUID []byte) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	websocketKey := ""
+	switch parsed.Scheme {
+	case "ws", "wss":
+		target := *parsed
+		target.Scheme = "http" + strings.TrimPrefix(parsed.Scheme, "ws")
+		if req, err = http.NewRequest(http.MethodGet, target.String(), nil); err != nil {
+			return err
+		}
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		websocketKey = base64.StdEncoding.EncodeToString(nonce)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", websocketKey)
+	default:
+		// AgentToServer { instance_uid (champ 1, octets) }
+		body := append([]byte{0x0a, byte(len(instanceUID))}, instanceUID...)
+		if req, err = http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("jeton refusé (HTTP %d)", resp.StatusCode)
+	case websocketKey != "" && resp.StatusCode == http.StatusSwitchingProtocols:
+		if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(websocketKey) {
+			return fmt.Errorf("poignée de main WebSocket invalide")
+		}
+		return nil
+	case websocketKey == "" && resp.StatusCode == http.StatusOK:
+		return nil
+	default:
+		return fmt.Errorf("réponse inattendue (HTTP %d)", resp.StatusCode)
+	}
+}
+
+// websocketAccept calcule la valeur Sec-WebSocket-Accept attendue pour une clé
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + WEBSOCKET_ACCEPT_GUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// opampInstanceUID retourne l'identifiant de l'agent sur 16 octets, annoncé
+// par l'opamp-supervisor ; un identifiant aléatoire à défaut
+func opampInstanceUID() []byte {
+	if id, stored, err := loadAgentID(); err == nil && stored {
+		if uid, err := hex.DecodeString(strings.ReplaceAll(id, "-", "")); err == nil && len(uid) == 16 {
+			return uid
+		}
+	}
+	uid := make([]byte, 16)
+	rand.Read(uid)
+	return uid
+}
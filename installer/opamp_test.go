@@ -0,0 +1,324 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testInstanceUID = "01928c4e-6f6a-7c3b-9d2e-3f1a5b7c9d0e"
+
+func TestRenderSupervisorConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        supervisorParams
+		authorization string
+	}{
+		{"websocket avec jeton et autorités", supervisorParams{
+			Endpoint: "wss://opamp.example:4320/v1/opamp",
+			Token:    envReference(ENV_OPAMP_TOKEN),
+			CAFile:   "/etc/smartsentry-agent/gateway-ca.pem",
+		}, "Bearer ${env:" + ENV_OPAMP_TOKEN + "}"},
+		{"http sans jeton ni autorités", supervisorParams{
+			Endpoint: "http://opamp.example/v1/opamp",
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Executable = "/usr/local/bin/otelcol-contrib"
+			tt.params.ConfigPath = "/etc/smartsentry-agent/config.yaml"
+			tt.params.StorageDir = "/var/lib/smartsentry-agent/opamp-supervisor"
+			content, err := renderSupervisorConfig(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var config supervisorConfig
+			if err := yaml.Unmarshal(content, &config); err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Server.Endpoint != tt.params.Endpoint {
+				t.Errorf("endpoint %q", config.Server.Endpoint)
+			}
+			if got := config.Server.Headers["Authorization"]; got != tt.authorization {
+				t.Errorf("Authorization %q, attendu %q", got, tt.authorization)
+			}
+			caFile := ""
+			if config.Server.TLS != nil {
+				caFile = config.Server.TLS.CAFile
+			}
+			if caFile != tt.params.CAFile {
+				t.Errorf("tls.ca_file %q, attendu %q", caFile, tt.params.CAFile)
+			}
+
+			capabilities := config.Capabilities
+			if !capabilities.AcceptsRemoteConfig || !capabilities.ReportsEffectiveConfig || !capabilities.ReportsRemoteConfig || !capabilities.ReportsHealth {
+				t.Errorf("capacités incomplètes : %+v", capabilities)
+			}
+			if config.Agent.Executable != tt.params.Executable {
+				t.Errorf("executable %q", config.Agent.Executable)
+			}
+			// config.yaml d'abord : la configuration distante l'emporte
+			want := []string{tt.params.ConfigPath, "$REMOTE_CONFIG", "$OPAMP_EXTENSION_CONFIG"}
+			if strings.Join(config.Agent.ConfigFiles, ",") != strings.Join(want, ",") {
+				t.Errorf("config_files %v, attendu %v", config.Agent.ConfigFiles, want)
+			}
+			if config.Storage.Directory != tt.params.StorageDir {
+				t.Errorf("storage.directory %q", config.Storage.Directory)
+			}
+		})
+	}
+
+	if _, err := renderSupervisorConfig(supervisorParams{Endpoint: "tcp://opamp.example:4320"}); err == nil {
+		t.Error("schéma tcp accepté")
+	}
+}
+
+func TestSetupOpAMPSupervisor(t *testing.T) {
+	useTestDirs(t)
+	storageDir, err := getSupervisorStorageDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(storageDir, SUPERVISOR_STATE_FILE)
+
+	// Agent non supervisé : rien n'est écrit
+	if err := setupOpAMPSupervisor(installOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if supervised() {
+		t.Fatal("supervisor.yaml écrit sans --opamp-endpoint")
+	}
+
+	if err := saveAgentID(testInstanceUID, false); err != nil {
+		t.Fatal(err)
+	}
+	opts := installOptions{OpAMPEndpoint: "wss://opamp.example:4320/v1/opamp", OpAMPToken: "opamp-secret"}
+	if err := setupOpAMPSupervisor(opts); err != nil {
+		t.Fatal(err)
+	}
+	if !supervised() {
+		t.Fatal("supervisor.yaml absent")
+	}
+	configPath, _ := getSupervisorConfigPath()
+	if content := readTestFile(t, configPath); strings.Contains(content, "opamp-secret") {
+		t.Errorf("jeton inscrit dans supervisor.yaml :\n%s", content)
+	}
+	// L'opamp-supervisor annonce l'identifiant de l'agent
+	if got := readTestFile(t, statePath); got != "instance_id: "+testInstanceUID+"\n" {
+		t.Errorf("état du supervisor %q", got)
+	}
+
+	// --reset-identity : l'identifiant annoncé suit, même sans --opamp-endpoint
+	const resetID = "01928c4e-7000-7abc-8def-0123456789ab"
+	if err := saveAgentID(resetID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupOpAMPSupervisor(installOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, statePath); got != "instance_id: "+resetID+"\n" {
+		t.Errorf("état du supervisor après réinitialisation %q", got)
+	}
+}
+
+func TestSupervisedUnit(t *testing.T) {
+	useTestDirs(t)
+	configPath, err := getSupervisorConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, configPath, "server:\n  endpoint: wss://opamp.example/v1/opamp\n")
+	storageDir, _ := getSupervisorStorageDirectory()
+
+	params := defaultUnitParams()
+	if !strings.Contains(strings.Join(params.ReadWritePaths, " "), storageDir) {
+		t.Errorf("répertoire de l'opamp-supervisor absent de ReadWritePaths : %v", params.ReadWritePaths)
+	}
+
+	params.UserMode = false
+	unit, err := renderSystemdUnit(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ExecStart=" + getSupervisorPath() + " --config=" + configPath + "\n"
+	if !strings.Contains(string(unit), want) {
+		t.Errorf("ExecStart de l'opamp-supervisor absent :\n%s", unit)
+	}
+	// SIGHUP ne parvient pas au collector : une modification redémarre le service
+	if strings.Contains(string(unit), "ExecReload=") {
+		t.Errorf("ExecReload inattendu :\n%s", unit)
+	}
+
+	for _, name := range []string{"openrc", "sysv"} {
+		script, err := renderServiceTemplate(name, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(script), `"`+getSupervisorPath()+`"`) || strings.Contains(string(script), params.BinaryPath) {
+			t.Errorf("script %s sans l'opamp-supervisor :\n%s", name, script)
+		}
+	}
+}
+
+func TestMergeRemovesOpAMPExtension(t *testing.T) {
+	rendered, err := renderConfig(packageDefaultTemplate, renderOptions{GatewayURL: "https://gateway.example:4318", Profile: PROFILE_STANDARD, TargetOS: "linux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Configuration d'une version antérieure, avec l'extension opamp en rapport seul
+	doc, root := parseTestConfig(t, rendered)
+	yamlSet(yamlEnsureMapping(root, "extensions"), "opamp", yamlMapping("instance_uid", testInstanceUID))
+	yamlAppendUnique(yamlEnsureMapping(root, "service"), "extensions", "opamp")
+	existing, err := marshalConfigDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := mergeConfigs(existing, rendered, renderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, root = parseTestConfig(t, merged)
+	if yamlLookup(root, "extensions", "opamp") != nil {
+		t.Error("extension opamp conservée")
+	}
+	for _, name := range yamlLookup(root, "service", "extensions").Content {
+		if name.Value == "opamp" {
+			t.Error("opamp conservée dans service.extensions")
+		}
+	}
+}
+
+// writeSupervisorTestConfig écrit un supervisor.yaml déclarant le serveur
+// OpAMP donné, avec le jeton de agent.env et les autorités éventuelles
+func writeSupervisorTestConfig(t *testing.T, endpoint, caFile string) string {
+	t.Helper()
+	content, err := renderSupervisorConfig(supervisorParams{Endpoint: endpoint, Token: envReference(ENV_OPAMP_TOKEN), CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), SUPERVISOR_CONFIG_FILE)
+	writeTestFile(t, path, string(content))
+	return path
+}
+
+// opampHandler simule un serveur OpAMP sur /v1/opamp acceptant le jeton
+// "opamp-secret" : poignée de main WebSocket ou message AgentToServer en HTTP
+func opampHandler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/opamp" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer opamp-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get("Content-Type") != "application/x-protobuf" || len(body) != 18 || body[0] != 0x0a || body[1] != 16 {
+				t.Errorf("AgentToServer invalide : %q %x", r.Header.Get("Content-Type"), body)
+			}
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			return
+		}
+
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("poignée de main WebSocket incomplète : %v", r.Header)
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		buf.WriteString("Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		buf.Flush()
+	}
+}
+
+func TestCheckOpAMP(t *testing.T) {
+	useTestDirs(t)
+	envPath, err := getEnvFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(envPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(opampHandler(t))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(opampHandler(t))
+	defer tlsServer.Close()
+	caFile := filepath.Join(t.TempDir(), "opamp-ca.pem")
+	writeTestFile(t, caFile, serverCertificatePEM(tlsServer))
+
+	// Port libéré aussitôt : aucune connexion possible
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	wssURL := "wss" + strings.TrimPrefix(tlsServer.URL, "https")
+	tests := []struct {
+		name     string
+		endpoint string
+		caFile   string
+		token    string
+		want     string
+		detail   string
+	}{
+		{"websocket", wsURL + "/v1/opamp", "", "opamp-secret", CHECK_PASS, "jeton accepté"},
+		{"websocket, jeton refusé", wsURL + "/v1/opamp", "", "mauvais-jeton", CHECK_FAIL, "jeton refusé (HTTP 401)"},
+		{"websocket, mauvais chemin", wsURL + "/opamp", "", "opamp-secret", CHECK_FAIL, "HTTP 404"},
+		{"http", server.URL + "/v1/opamp", "", "opamp-secret", CHECK_PASS, "jeton accepté"},
+		{"http, jeton refusé", server.URL + "/v1/opamp", "", "mauvais-jeton", CHECK_FAIL, "jeton refusé (HTTP 401)"},
+		{"wss avec les autorités configurées", wssURL + "/v1/opamp", caFile, "opamp-secret", CHECK_PASS, "jeton accepté"},
+		{"wss sans les autorités", wssURL + "/v1/opamp", "", "opamp-secret", CHECK_FAIL, "certificate"},
+		{"serveur arrêté", "ws" + strings.TrimPrefix(closedURL, "http") + "/v1/opamp", "", "opamp-secret", CHECK_FAIL, "refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := updateEnvFile(envPath, map[string]string{ENV_OPAMP_TOKEN: tt.token}); err != nil {
+				t.Fatal(err)
+			}
+			report := &doctorReport{}
+			checkOpAMP(report, writeSupervisorTestConfig(t, tt.endpoint, tt.caFile))
+			if len(report.Checks) != 1 {
+				t.Fatalf("vérifications : %+v", report.Checks)
+			}
+			check := report.Checks[0]
+			if check.Status != tt.want || !strings.Contains(check.Detail, tt.detail) {
+				t.Errorf("statut %s (%s), attendu %s (%s)", check.Status, check.Detail, tt.want, tt.detail)
+			}
+		})
+	}
+
+	t.Run("agent non supervisé", func(t *testing.T) {
+		report := &doctorReport{}
+		checkOpAMP(report, filepath.Join(t.TempDir(), SUPERVISOR_CONFIG_FILE))
+		if len(report.Checks) != 0 {
+			t.Errorf("vérification inattendue : %+v", report.Checks)
+		}
+	})
+}
+
+func TestSupervisorDownloadURL(t *testing.T) {
+	got := getSupervisorDownloadURL("linux", "arm64")
+	want := "/releases/download/cmd%2Fopampsupervisor%2Fv" + OTEL_VERSION + "/opampsupervisor_" + OTEL_VERSION + "_linux_arm64"
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("URL %s", got)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/goreleaser/nfpm/v2"
+	_ "github.com/goreleaser/nfpm/v2/deb"
+	"github.com/goreleaser/nfpm/v2/files"
+	_ "github.com/goreleaser/nfpm/v2/rpm"
+)
+
+const (
+	// Nom des paquets .deb et .rpm
+	PACKAGE_NAME = "smartsentry-agent"
+
+	// Emplacements des fichiers livrés par le paquet. Le collector n'est pas
+	// dans /usr/local (réservé à l'administrateur) ni dans /usr/bin, où le
+	// paquet otelcol-contrib amont installe le sien
+	PACKAGE_COLLECTOR_PATH = "/usr/lib/smartsentry-agent/otelcol-contrib"
+	PACKAGE_INSTALLER_PATH = "/usr/sbin/smartsentry-installer"
+	PACKAGE_TEMPLATE_PATH  = "/usr/share/smartsentry-agent/linux-default-config.yaml"
+	PACKAGE_UNIT_PATH      = "/usr/lib/systemd/system/" + SERVICE_NAME + ".service"
+)
+
+// packageDefaultTemplate est le modèle de configuration livré par défaut dans
+// les paquets, copie de configs/linux-default-config.yaml à la racine du dépôt
+//
+//go:generate cp ../configs/linux-default-config.yaml configs/linux-default-config.yaml
+//go:embed configs/linux-default-config.yaml
+var packageDefaultTemplate []byte
+
+// packageFormats liste les formats produits par la commande package
+var packageFormats = []string{"deb", "rpm"}
+
+// packageScriptParams regroupe les paramètres des scripts de maintenance
+type packageScriptParams struct {
+	PackageName   string
+	InstallerPath string
+	ConfigDir     string
+	StateDir      string
+	LogDir        string
+}
+
+// packageOptions regroupe les options de la commande package
+type packageOptions struct {
+	Version        string
+	Release        string
+	Arch           string
+	Formats        []string
+	InstallerPath  string
+	CollectorPath  string
+	ConfigTemplate string
+	Maintainer     string
+	OutputDir      string
+}
+
+// runPackage traite la commande package : paquets .deb et .rpm contenant le
+// collector, l'installateur, le modèle de configuration et l'unité systemd
+func runPackage(args []string) error {
+	var opts packageOptions
+	fs := flag.NewFlagSet("package", flag.ExitOnError)
+	fs.StringVar(&opts.Version, "version", "", "version des paquets (ex: 1.2.0)")
+	fs.StringVar(&opts.Release, "release", "1", "révision du paquet pour une même version")
+	fs.StringVar(&opts.Arch, "arch", runtime.GOARCH, "architecture cible : amd64 ou arm64")
+	formats := fs.String("format", strings.Join(packageFormats, ","), "formats à produire : deb, rpm ou deb,rpm")
+	fs.StringVar(&opts.InstallerPath, "installer", "", "binaire de l'installateur pour l'architecture cible (par défaut l'exécutable courant)")
+	fs.StringVar(&opts.CollectorPath, "collector", "", "binaire otelcol-contrib pour l'architecture cible (téléchargé depuis les releases OpenTelemetry si vide)")
+	fs.StringVar(&opts.ConfigTemplate, "config-template", "", "modèle de configuration livré (configs/linux-default-config.yaml embarqué si vide)")
+	fs.StringVar(&opts.Maintainer, "maintainer", "SmartSentry <smartsentry@localhost>", "mainteneur déclaré dans les paquets")
+	fs.StringVar(&opts.OutputDir, "output", "dist", "répertoire des paquets produits")
+	fs.Parse(args)
+
+	// Les chemins installés (binaire, configuration, état) sont ceux de Linux
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("les paquets doivent être construits sous Linux")
+	}
+	userMode = false
+
+	if opts.Version == "" {
+		return fmt.Errorf("l'option --version est obligatoire")
+	}
+	if opts.Arch != "amd64" && opts.Arch != "arm64" {
+		return fmt.Errorf("architecture non supportée : %s (amd64 ou arm64)", opts.Arch)
+	}
+	for _, format := range strings.Split(*formats, ",") {
+		format = strings.TrimSpace(format)
+		if format != "deb" && format != "rpm" {
+			return fmt.Errorf("format inconnu : %s (deb ou rpm)", format)
+		}
+		opts.Formats = append(opts.Formats, format)
+	}
+	if opts.InstallerPath == "" {
+		if opts.Arch != runtime.GOARCH {
+			return fmt.Errorf("--installer est obligatoire pour l'architecture %s", opts.Arch)
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("exécutable courant introuvable : %w", err)
+		}
+		opts.InstallerPath = executable
+	}
+
+	workDir, err := os.MkdirTemp("", "smartsentry-package-")
+	if err != nil {
+		return fmt.Errorf("impossible de créer le répertoire temporaire : %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	info, err := stagePackage(opts, workDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return fmt.Errorf("impossible de créer %s : %w", opts.OutputDir, err)
+	}
+	for _, format := range opts.Formats {
+		path, err := buildPackage(format, *info, opts.OutputDir)
+		if err != nil {
+			return fmt.Errorf("échec du paquet %s : %w", format, err)
+		}
+		slog.Info("Paquet construit", "format", format, "path", path)
+	}
+	return nil
+}
+
+// stagePackage prépare dans workDir les fichiers et scripts du paquet et
+// retourne sa description nfpm
+func stagePackage(opts packageOptions, workDir string) (*nfpm.Info, error) {
+	collector := opts.CollectorPath
+	if collector == "" {
+		var err error
+		collector, err = fetchCollectorBinary("linux", opts.Arch, workDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	template := packageDefaultTemplate
+	if opts.ConfigTemplate != "" {
+		var err error
+		template, err = os.ReadFile(opts.ConfigTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("impossible de lire le modèle de configuration : %w", err)
+		}
+	}
+
+	// Unité générique livrée dans /usr/lib ; la post-installation écrit dans
+	// /etc l'unité adaptée à la configuration (droits ciblés), prioritaire
+	params := defaultUnitParams()
+	params.BinaryPath = PACKAGE_COLLECTOR_PATH
+	params.EnvironmentFile = "-" + filepath.Join(filepath.Dir(params.ConfigPath), ENV_FILE_NAME)
+	unit, err := renderSystemdUnit(params)
+	if err != nil {
+		return nil, fmt.Errorf("impossible de générer l'unité systemd : %w", err)
+	}
+
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return nil, err
+	}
+	scriptParams := packageScriptParams{
+		PackageName:   PACKAGE_NAME,
+		InstallerPath: PACKAGE_INSTALLER_PATH,
+		ConfigDir:     filepath.Dir(params.ConfigPath),
+		StateDir:      stateDir,
+		LogDir:        getLogDirectory(),
+	}
+
+	staged := map[string][]byte{"config-template.yaml": template, "unit.service": unit}
+	for _, script := range []string{"postinstall", "preremove", "postremove"} {
+		content, err := renderServiceTemplate(script, scriptParams)
+		if err != nil {
+			return nil, fmt.Errorf("impossible de générer le script %s : %w", script, err)
+		}
+		staged[script+".sh"] = content
+	}
+	for name, content := range staged {
+		if err := os.WriteFile(filepath.Join(workDir, name), content, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &nfpm.Info{
+		Name:        PACKAGE_NAME,
+		Arch:        opts.Arch,
+		Platform:    "linux",
+		Version:     opts.Version,
+		Release:     opts.Release,
+		Section:     "admin",
+		Maintainer:  opts.Maintainer,
+		Description: fmt.Sprintf("SmartSentry Observability Agent (OpenTelemetry Collector Contrib %s)", OTEL_VERSION),
+		Vendor:      "SmartSentry",
+		Homepage:    "https://github.com/Arceuid731/smartsentry-agent",
+		Overridables: nfpm.Overridables{
+			Contents: files.Contents{
+				{Source: collector, Destination: PACKAGE_COLLECTOR_PATH, FileInfo: &files.ContentFileInfo{Mode: 0755}},
+				{Source: opts.InstallerPath, Destination: PACKAGE_INSTALLER_PATH, FileInfo: &files.ContentFileInfo{Mode: 0755}},
+				{Source: filepath.Join(workDir, "config-template.yaml"), Destination: PACKAGE_TEMPLATE_PATH, FileInfo: &files.ContentFileInfo{Mode: 0644}},
+				{Source: filepath.Join(workDir, "unit.service"), Destination: PACKAGE_UNIT_PATH, FileInfo: &files.ContentFileInfo{Mode: 0644}},
+			},
+			Scripts: nfpm.Scripts{
+				PostInstall: filepath.Join(workDir, "postinstall.sh"),
+				PreRemove:   filepath.Join(workDir, "preremove.sh"),
+				PostRemove:  filepath.Join(workDir, "postremove.sh"),
+			},
+		},
+	}
+	return nfpm.WithDefaults(info), nil
+}
+
+// buildPackage écrit le paquet au format demandé dans outputDir
+func buildPackage(format string, info nfpm.Info, outputDir string) (string, error) {
+	packager, err := nfpm.Get(format)
+	if err != nil {
+		return "", err
+	}
+
+	// La préparation modifie la liste des fichiers : une copie par format
+	info.Contents = append(files.Contents(nil), info.Contents...)
+	if err := nfpm.PrepareForPackager(&info, format); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(outputDir, packager.ConventionalFileName(&info))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := packager.Package(&info, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,152 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// PACKAGE_ENV_PROFILE choisit le profil de collecte lors d'une installation
+// par paquet (l'endpoint et le jeton utilisent les variables de agent.env)
+const PACKAGE_ENV_PROFILE = "SMARTSENTRY_PROFILE"
+
+// runPackageHook traite la commande interne package-hook appelée par les
+// scripts de maintenance des paquets .deb et .rpm
+func runPackageHook(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage : package-hook post-install [install|upgrade] | pre-remove")
+	}
+	userMode = false
+
+	switch args[0] {
+	case "post-install":
+		upgrade := len(args) > 1 && args[1] == "upgrade"
+		return packagePostInstall(upgrade)
+	case "pre-remove":
+		return packagePreRemove()
+	default:
+		return fmt.Errorf("étape inconnue : %s", args[0])
+	}
+}
+
+// packagePostInstall configure l'agent après installation ou mise à jour du
+// paquet avec la logique de la commande install : utilisateur de service,
+// configuration, unité systemd, activation et démarrage avec retour arrière
+func packagePostInstall(upgrade bool) error {
+	if upgrade {
+		slog.Info("Mise à jour du paquet", "package", PACKAGE_NAME)
+	} else {
+		slog.Info("Installation du paquet", "package", PACKAGE_NAME)
+	}
+
+	// Le binaire est fourni par le paquet
+	if err := saveRuntimeChoice(RUNTIME_BINARY, ""); err != nil {
+		slog.Warn("Impossible de mémoriser le mode d'exécution", "error", err)
+	}
+
+	opts := installOptions{
+		ConfigPolicy:   CONFIG_POLICY_KEEP,
+		ConfigTemplate: PACKAGE_TEMPLATE_PATH,
+		Profile:        PROFILE_STANDARD,
+		Tags:           tagFlag{},
+		Runtime:        RUNTIME_BINARY,
+		ServiceManager: SERVICE_MANAGER_SYSTEMD,
+		ReadyTimeout:   60 * time.Second,
+		JournalLines:   30,
+	}
+	if profile := os.Getenv(PACKAGE_ENV_PROFILE); profile != "" {
+		if !validProfile(profile) {
+			return fmt.Errorf("profil inconnu dans %s : %s", PACKAGE_ENV_PROFILE, profile)
+		}
+		opts.Profile = profile
+	}
+
+	configured, err := ensurePackageConfiguration(opts)
+	if err != nil {
+		return err
+	}
+	if !configured {
+		return prepareServiceAccount()
+	}
+
+	// Construction d'image ou chroot : les fichiers sont prêts, le service sera
+	// activé par une nouvelle exécution du hook sur le système démarré
+	if detectServiceManager() != SERVICE_MANAGER_SYSTEMD {
+		slog.Warn("systemd inactif : service non activé ; sur le système démarré, relancez le script de post-installation",
+			"command", "sudo "+PACKAGE_INSTALLER_PATH+" package-hook post-install")
+		if err := prepareServiceAccount(); err != nil {
+			return err
+		}
+		updateInstallManifest()
+		return nil
+	}
+
+	manager := &systemdManager{wait: serviceWait{ReadyTimeout: opts.ReadyTimeout, JournalLines: opts.JournalLines}}
+	if err := manager.Install(opts); err != nil {
+		return err
+	}
+	if err := saveServiceManagerChoice(manager.Name()); err != nil {
+		slog.Warn("Impossible de mémoriser le gestionnaire de service", "error", err)
+	}
+
+	// Un échec de démarrage ne doit pas laisser le paquet à moitié configuré :
+	// la configuration précédente est restaurée et l'erreur signalée
+	slog.Info("Démarrage du service", "service", SERVICE_NAME)
+	if err := restartWithRollback(manager.Start); err != nil {
+		slog.Warn("Service non démarré", "error", err, "diagnostic", "sudo "+PACKAGE_INSTALLER_PATH+" doctor")
+	}
+
+	updateInstallManifest()
+	return nil
+}
+
+// ensurePackageConfiguration conserve la configuration existante ou la génère
+// depuis le modèle livré ; l'endpoint du Gateway provient de l'environnement
+// ou d'un agent.env déposé avant l'installation (gestion de configuration)
+func ensurePackageConfiguration(opts installOptions) (bool, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		slog.Info("Configuration existante conservée", "path", configPath)
+		return true, nil
+	}
+
+	opts.GatewayURL, opts.Token = os.Getenv(ENV_GATEWAY_ENDPOINT), os.Getenv(ENV_TOKEN)
+	if envPath, err := getEnvFilePath(); err == nil {
+		if values, err := readEnvFile(envPath); err == nil {
+			if opts.GatewayURL == "" {
+				opts.GatewayURL = values[ENV_GATEWAY_ENDPOINT]
+			}
+			if opts.Token == "" {
+				opts.Token = values[ENV_TOKEN]
+			}
+		}
+	}
+
+	if opts.GatewayURL == "" {
+		slog.Warn("Aucun Gateway configuré : l'agent est installé mais pas démarré ; renseignez la variable dans agent.env (ou l'environnement) puis relancez le script de post-installation",
+			"variable", ENV_GATEWAY_ENDPOINT, "command", "sudo "+PACKAGE_INSTALLER_PATH+" package-hook post-install")
+		return false, nil
+	}
+
+	if err := setupConfiguration(opts); err != nil {
+		return false, fmt.Errorf("échec de la configuration : %w", err)
+	}
+	return true, nil
+}
+
+// packagePreRemove arrête et désactive le service avant la suppression du
+// paquet, et retire l'unité générée dans /etc
+func packagePreRemove() error {
+	manager, err := newServiceManager(installedServiceManagerName(), serviceWait{})
+	if err != nil {
+		return err
+	}
+	slog.Info("Arrêt du service", "service", SERVICE_NAME)
+	return manager.Uninstall()
+}
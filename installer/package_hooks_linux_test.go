@@ -0,0 +1,104 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usePackageTestDirs redirige la configuration et l'état vers un répertoire
+// temporaire (voir useTestDirs) et retourne les options du hook
+func usePackageTestDirs(t *testing.T) installOptions {
+	t.Helper()
+	dir := useTestDirs(t)
+	t.Setenv(ENV_GATEWAY_ENDPOINT, "")
+	t.Setenv(ENV_TOKEN, "")
+
+	template := filepath.Join(dir, "linux-default-config.yaml")
+	if err := os.WriteFile(template, packageDefaultTemplate, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return installOptions{
+		ConfigPolicy:   CONFIG_POLICY_KEEP,
+		ConfigTemplate: template,
+		Profile:        PROFILE_STANDARD,
+		Tags:           tagFlag{},
+		Runtime:        RUNTIME_BINARY,
+		ServiceManager: SERVICE_MANAGER_SYSTEMD,
+		ReadyTimeout:   time.Second,
+	}
+}
+
+func TestEnsurePackageConfigurationKeepsExistingOnUpgrade(t *testing.T) {
+	opts := usePackageTestDirs(t)
+	t.Setenv(ENV_GATEWAY_ENDPOINT, "https://new-gateway:4318")
+
+	configPath, _ := getConfigPath()
+	stateDir, _ := getStateDirectory()
+	agentIDPath := filepath.Join(stateDir, AGENT_ID_FILE)
+	const config = "# modifié localement\nreceivers: {}\n"
+	const agentID = "01928c4e-6f6a-7c3b-9d2e-3f1a5b7c9d0e\n"
+	writeTestFile(t, configPath, config)
+	writeTestFile(t, agentIDPath, agentID)
+
+	configured, err := ensurePackageConfiguration(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configured {
+		t.Error("configuration existante non reconnue")
+	}
+	if got := readTestFile(t, configPath); got != config {
+		t.Errorf("config.yaml modifié :\n%s", got)
+	}
+	if got := readTestFile(t, agentIDPath); got != agentID {
+		t.Errorf("agent-id modifié : %q", got)
+	}
+}
+
+func TestEnsurePackageConfigurationReusesAgentID(t *testing.T) {
+	opts := usePackageTestDirs(t)
+	t.Setenv(ENV_GATEWAY_ENDPOINT, "https://gateway:4318")
+
+	// Configuration supprimée mais état conservé : l'identifiant est repris
+	stateDir, _ := getStateDirectory()
+	const agentID = "01928c4e-6f6a-7c3b-9d2e-3f1a5b7c9d0e"
+	writeTestFile(t, filepath.Join(stateDir, AGENT_ID_FILE), agentID+"\n")
+
+	configured, err := ensurePackageConfiguration(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configured {
+		t.Fatal("configuration non générée malgré le Gateway fourni")
+	}
+
+	configPath, _ := getConfigPath()
+	if config := readTestFile(t, configPath); !strings.Contains(config, `"`+agentID+`"`) {
+		t.Errorf("service.instance.id ne reprend pas l'identifiant enregistré :\n%s", config)
+	}
+	envPath, _ := getEnvFilePath()
+	if env := readTestFile(t, envPath); !strings.Contains(env, "https://gateway:4318") {
+		t.Errorf("agent.env sans l'endpoint du Gateway :\n%s", env)
+	}
+}
+
+func TestEnsurePackageConfigurationWithoutGateway(t *testing.T) {
+	opts := usePackageTestDirs(t)
+
+	configured, err := ensurePackageConfiguration(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured {
+		t.Error("configuration générée sans Gateway")
+	}
+	configPath, _ := getConfigPath()
+	if _, err := os.Stat(configPath); !os.IsNotExist(err) {
+		t.Errorf("config.yaml créé sans Gateway : %v", err)
+	}
+}
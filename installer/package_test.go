@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// runMaintainerScript exécute un script de maintenance avec les arguments de
+// dpkg ou rpm et retourne les appels faits à l'installateur (vide si aucun)
+func runMaintainerScript(t *testing.T, script string, args ...string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts de maintenance shell")
+	}
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	installer := filepath.Join(dir, "smartsentry-installer")
+	fake := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if err := os.WriteFile(installer, []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := renderServiceTemplate(script, packageScriptParams{
+		PackageName:   PACKAGE_NAME,
+		InstallerPath: installer,
+		ConfigDir:     filepath.Join(dir, "etc"),
+		StateDir:      filepath.Join(dir, "state"),
+		LogDir:        filepath.Join(dir, "log"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, script+".sh")
+	if err := os.WriteFile(path, content, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if output, err := exec.Command("sh", append([]string{path}, args...)...).CombinedOutput(); err != nil {
+		t.Fatalf("%s %v : %v\n%s", script, args, err, output)
+	}
+	recorded, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(recorded))
+}
+
+func TestPostInstallActionMapping(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"dpkg première installation", []string{"configure", ""}, "package-hook post-install install"},
+		{"dpkg sans version précédente", []string{"configure"}, "package-hook post-install install"},
+		{"dpkg mise à jour", []string{"configure", "1.0.0-1"}, "package-hook post-install upgrade"},
+		{"dpkg abandon de mise à jour", []string{"abort-upgrade", "1.1.0-1"}, ""},
+		{"rpm première installation", []string{"1"}, "package-hook post-install install"},
+		{"rpm mise à jour", []string{"2"}, "package-hook post-install upgrade"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runMaintainerScript(t, "postinstall", tt.args...); got != tt.want {
+				t.Errorf("appel %q, attendu %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreRemoveSkipsUpgrade(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"dpkg suppression", []string{"remove"}, "package-hook pre-remove"},
+		{"dpkg mise à jour", []string{"upgrade", "1.1.0-1"}, ""},
+		{"rpm suppression", []string{"0"}, "package-hook pre-remove"},
+		{"rpm mise à jour", []string{"1"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runMaintainerScript(t, "preremove", tt.args...); got != tt.want {
+				t.Errorf("appel %q, attendu %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackageDefaultTemplateMatchesRepository(t *testing.T) {
+	// La copie embarquée est mise à jour par go generate
+	source, err := os.ReadFile(filepath.Join("..", "configs", "linux-default-config.yaml"))
+	if err != nil {
+		t.Skip("configs/ absent : ", err)
+	}
+	if string(source) != string(packageDefaultTemplate) {
+		t.Error("configs/linux-default-config.yaml modifié : lancez go generate")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// privilegeRequirement décrit les droits supplémentaires nécessaires à un
+// receiver lorsque le collector tourne sous l'utilisateur non privilégié
+type privilegeRequirement struct {
+	Receiver     string
+	Reason       string
+	Capabilities []string
+	Groups       []string
+}
+
+// analyzePrivileges détermine, à partir de la configuration rendue, les
+// capabilities et groupes supplémentaires requis par les receivers activés
+func analyzePrivileges(config []byte) ([]privilegeRequirement, error) {
+	doc, err := parseYAMLDocument(config)
+	if err != nil {
+		return nil, err
+	}
+	root := yamlRoot(doc)
+	receivers := yamlGet(root, "receivers")
+	if receivers == nil {
+		return nil, nil
+	}
+
+	var requirements []privilegeRequirement
+	for i := 0; i+1 < len(receivers.Content); i += 2 {
+		id := receivers.Content[i].Value
+		receiver := receivers.Content[i+1]
+
+		switch componentType(id) {
+		case "hostmetrics":
+			if yamlLookup(receiver, "scrapers", "process") != nil {
+				requirements = append(requirements, privilegeRequirement{
+					Receiver:     id + "/process",
+					Reason:       "lecture de /proc/<pid> (exe, io, fd) des processus des autres utilisateurs",
+					Capabilities: []string{"CAP_SYS_PTRACE", "CAP_DAC_READ_SEARCH"},
+				})
+			}
+		case "filelog":
+			requirements = append(requirements, privilegeRequirement{
+				Receiver: id,
+				Reason:   "lecture des journaux texte de /var/log",
+				Groups:   []string{"adm"},
+			})
+		case "journald":
+			requirements = append(requirements, privilegeRequirement{
+				Receiver: id,
+				Reason:   "lecture du journal systemd",
+				Groups:   []string{"systemd-journal"},
+			})
+		}
+	}
+
+	return requirements, nil
+}
+
+// mergePrivileges regroupe les capabilities et groupes requis, sans doublon et triés
+func mergePrivileges(requirements []privilegeRequirement) ([]string, []string) {
+	capabilities := map[string]bool{}
+	groups := map[string]bool{}
+	for _, requirement := range requirements {
+		for _, capability := range requirement.Capabilities {
+			capabilities[capability] = true
+		}
+		for _, group := range requirement.Groups {
+			groups[group] = true
+		}
+	}
+	return sortedKeys(capabilities), sortedKeys(groups)
+}
+
+// printPrivilegeRequirements explique quels droits sont accordés et pourquoi
+func printPrivilegeRequirements(requirements []privilegeRequirement) {
+	if len(requirements) == 0 {
+		slog.Info("Aucun droit supplémentaire requis : le collector tourne sans capability")
+		return
+	}
+
+	for _, requirement := range requirements {
+		grants := append(append([]string(nil), requirement.Capabilities...), prefixAll("groupe ", requirement.Groups)...)
+		slog.Info("Droits accordés au service", "receiver", requirement.Receiver, "grants", strings.Join(grants, ", "), "reason", requirement.Reason)
+	}
+}
+
+// sortedKeys retourne les clés d'un ensemble, triées
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// prefixAll préfixe chaque élément d'une liste
+func prefixAll(prefix string, values []string) []string {
+	prefixed := make([]string, 0, len(values))
+	for _, value := range values {
+		prefixed = append(prefixed, prefix+value)
+	}
+	return prefixed
+}
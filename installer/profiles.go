@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Profils de collecte disponibles (--profile)
+	PROFILE_MINIMAL  = "minimal"  // CPU, mémoire, charge et systèmes de fichiers
+	PROFILE_STANDARD = "standard" // configuration par défaut livrée dans configs/
+	PROFILE_FULL     = "full"     // standard + processus et journaux système
+)
+
+// minimalScrapers liste les scrapers hostmetrics conservés par le profil minimal
+var minimalScrapers = map[string]bool{"cpu": true, "memory": true, "load": true, "filesystem": true}
+
+// systemLogFiles sont les journaux texte collectés par le profil full
+var systemLogFiles = []string{"/var/log/syslog", "/var/log/messages", "/var/log/auth.log", "/var/log/secure"}
+
+// validProfile indique si un profil est reconnu
+func validProfile(profile string) bool {
+	switch profile {
+	case PROFILE_MINIMAL, PROFILE_STANDARD, PROFILE_FULL:
+		return true
+	default:
+		return false
+	}
+}
+
+// applyProfile adapte les receivers du modèle au profil de collecte choisi
+func applyProfile(root *yaml.Node, profile, targetOS string) error {
+	scrapers := yamlLookup(root, "receivers", "hostmetrics", "scrapers")
+
+	switch profile {
+	case PROFILE_STANDARD:
+		return nil
+
+	case PROFILE_MINIMAL:
+		if scrapers == nil {
+			return nil
+		}
+		for i := 0; i+1 < len(scrapers.Content); {
+			if minimalScrapers[scrapers.Content[i].Value] {
+				i += 2
+				continue
+			}
+			scrapers.Content = append(scrapers.Content[:i], scrapers.Content[i+2:]...)
+		}
+		return nil
+
+	case PROFILE_FULL:
+		if scrapers != nil {
+			// process : métriques par processus (nécessite l'accès à /proc des autres utilisateurs)
+			yamlSet(scrapers, "process", processScraperConfig())
+			yamlSet(scrapers, "processes", yamlMapping())
+		}
+		if targetOS == "linux" {
+			addSystemLogReceivers(root)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("profil inconnu : %s", profile)
+	}
+}
+
+// userModeExcludedScrapers sont les scrapers qui nécessitent de lire les
+// processus des autres utilisateurs, impossibles sans privilèges
+var userModeExcludedScrapers = []string{"process"}
+
+// restrictForUserMode retire de la configuration ce qu'un utilisateur non
+// privilégié ne peut pas collecter : processus des autres utilisateurs et
+// journaux système
+func restrictForUserMode(root *yaml.Node) {
+	scrapers := yamlLookup(root, "receivers", "hostmetrics", "scrapers")
+	for _, scraper := range userModeExcludedScrapers {
+		if yamlDelete(scrapers, scraper) {
+			slog.Warn("Scraper retiré en mode utilisateur (processus des autres utilisateurs illisibles)", "scraper", scraper)
+		}
+	}
+
+	for _, receiver := range []string{"filelog/system", "journald"} {
+		if removeReceiver(root, receiver) {
+			slog.Warn("Receiver retiré en mode utilisateur (journaux système illisibles)", "receiver", receiver)
+		}
+	}
+}
+
+// removeReceiver retire un receiver et ses références dans les pipelines,
+// puis les pipelines restés sans receiver (refusés par le collector)
+func removeReceiver(root *yaml.Node, receiver string) bool {
+	if !yamlDelete(yamlGet(root, "receivers"), receiver) {
+		return false
+	}
+
+	pipelines := yamlLookup(root, "service", "pipelines")
+	for i := 0; pipelines != nil && i+1 < len(pipelines.Content); {
+		yamlRemoveFromSequence(pipelines.Content[i+1], "receivers", receiver)
+		if receivers := yamlGet(pipelines.Content[i+1], "receivers"); receivers != nil && len(receivers.Content) == 0 {
+			pipelines.Content = append(pipelines.Content[:i], pipelines.Content[i+2:]...)
+			continue
+		}
+		i += 2
+	}
+	return true
+}
+
+// processScraperConfig ignore les erreurs de lecture sur les processus
+// éphémères ou protégés plutôt que de faire échouer tout le scrape
+func processScraperConfig() *yaml.Node {
+	return yamlMapping("mute_process_name_error", "true", "mute_process_exe_error", "true", "mute_process_io_error", "true")
+}
+
+// addSystemLogReceivers ajoute la collecte des journaux système (fichiers et
+// journald) et le pipeline de logs correspondant
+func addSystemLogReceivers(root *yaml.Node) {
+	receivers := yamlEnsureMapping(root, "receivers")
+
+	include := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+	for _, path := range systemLogFiles {
+		include.Content = append(include.Content, yamlScalar(path))
+	}
+	filelog := yamlMapping("start_at", "end")
+	yamlSet(filelog, "include", include)
+	yamlSet(receivers, "filelog/system", filelog)
+
+	yamlSet(receivers, "journald", yamlMapping("directory", "/var/log/journal"))
+
+	// Le pipeline de logs réutilise les processors et exporters des métriques
+	pipelines := yamlEnsureMapping(root, "service", "pipelines")
+	metrics := yamlGet(pipelines, "metrics")
+	logs := yamlMapping()
+	yamlSet(logs, "receivers", &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle,
+		Content: []*yaml.Node{yamlScalar("filelog/system"), yamlScalar("journald")}})
+	for _, key := range []string{"processors", "exporters"} {
+		if value := yamlGet(metrics, key); value != nil {
+			// Copie pour que les deux pipelines restent modifiables séparément
+			clone := *value
+			clone.Content = append([]*yaml.Node(nil), value.Content...)
+			yamlSet(logs, key, &clone)
+		}
+	}
+	yamlSet(pipelines, "logs", logs)
+}
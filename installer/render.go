@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Endpoints locaux des extensions de diagnostic optionnelles
+	PPROF_ENDPOINT  = "127.0.0.1:1777"
+	ZPAGES_ENDPOINT = "127.0.0.1:55679"
+)
+
+// renderOptions regroupe les paramètres appliqués au modèle de configuration
+type renderOptions struct {
+	GatewayURL string
+	Token      string
+
+	// Autorités de certification du Gateway (enrôlement) : vérification TLS
+	CAFile string
+
+	// Identifiant stable de l'agent (service.instance.id) et machine-id de
+	// l'hôte (host.id), à titre de référence
+	InstanceUID string
+	MachineID   string
+
+	// Référencer l'endpoint et le jeton via ${env:...} (fichier agent.env)
+	// plutôt que d'inscrire leurs valeurs dans config.yaml
+	UseEnvFile bool
+
+	Profile  string
+	TargetOS string
+	Tags     map[string]string
+	UserMode bool
+
+	// Collector exécuté dans un conteneur (racine de l'hôte sous HOSTFS_ROOT)
+	Container bool
+
+	EnablePprof  bool
+	EnableZPages bool
+}
+
+// renderConfig produit la configuration finale du collector à partir du
+// modèle téléchargé : endpoint du Gateway, extensions de diagnostic et
+// télémétrie interne exposée en local
+func renderConfig(template []byte, opts renderOptions) ([]byte, error) {
+	content := renderConfigWithGateway(template, opts.GatewayURL)
+
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	root := yamlRoot(doc)
+
+	if err := applyProfile(root, opts.Profile, opts.TargetOS); err != nil {
+		return nil, err
+	}
+	if opts.UserMode {
+		restrictForUserMode(root)
+	}
+	if opts.Container {
+		adaptForContainer(root)
+	}
+
+	if err := setGatewayExporter(root, gatewayEndpoint(opts), gatewayToken(opts), opts.Token != ""); err != nil {
+		return nil, err
+	}
+	if opts.CAFile != "" {
+		setGatewayTLS(root, opts.CAFile)
+	}
+
+	if opts.InstanceUID != "" {
+		setAgentIdentity(root, opts.InstanceUID, opts.MachineID)
+	}
+
+	if err := setResourceTags(root, opts.Tags); err != nil {
+		return nil, err
+	}
+
+	enableExtension(root, "health_check", HEALTH_CHECK_ENDPOINT)
+	if opts.EnablePprof {
+		enableExtension(root, "pprof", PPROF_ENDPOINT)
+	}
+	if opts.EnableZPages {
+		enableExtension(root, "zpages", ZPAGES_ENDPOINT)
+	}
+
+	configureTelemetry(root)
+
+	return marshalConfigDocument(doc)
+}
+
+// gatewayEndpoint et gatewayToken retournent les valeurs inscrites dans
+// config.yaml : référence à agent.env ou valeur littérale
+func gatewayEndpoint(opts renderOptions) string {
+	if opts.UseEnvFile {
+		return envReference(ENV_GATEWAY_ENDPOINT)
+	}
+	return opts.GatewayURL
+}
+
+func gatewayToken(opts renderOptions) string {
+	if opts.UseEnvFile {
+		return envReference(ENV_TOKEN)
+	}
+	return opts.Token
+}
+
+// setGatewayExporter fixe l'endpoint de l'exporter OTLP HTTP vers le Gateway
+// et, si un jeton est utilisé, l'en-tête d'authentification
+func setGatewayExporter(root *yaml.Node, endpoint, token string, withToken bool) error {
+	exporters := yamlGet(root, "exporters")
+	if exporters == nil || exporters.Kind != yaml.MappingNode {
+		return fmt.Errorf("la configuration ne déclare aucun exporter")
+	}
+
+	found := false
+	for i := 0; i+1 < len(exporters.Content); i += 2 {
+		if componentType(exporters.Content[i].Value) != "otlphttp" {
+			continue
+		}
+		exporter := exporters.Content[i+1]
+		if exporter.Kind != yaml.MappingNode {
+			exporter = yamlMapping()
+			exporters.Content[i+1] = exporter
+		}
+
+		yamlSet(exporter, "endpoint", yamlScalar(endpoint))
+		if withToken {
+			yamlSet(yamlEnsureMapping(exporter, "headers"), "Authorization", yamlScalar("Bearer "+token))
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("aucun exporter otlphttp vers le Gateway dans la configuration")
+	}
+	return nil
+}
+
+// setResourceTag ajoute (ou met à jour) un attribut de ressource statique via
+// le processor resource ; une valeur vide supprime le tag
+func setResourceTag(root *yaml.Node, key, value string) error {
+	if key == "" {
+		return fmt.Errorf("nom de tag vide")
+	}
+	setResourceAttribute(root, key, value, "upsert")
+	return nil
+}
+
+// setResourceTags applique les tags dans l'ordre de leurs noms
+func setResourceTags(root *yaml.Node, tags map[string]string) error {
+	for _, key := range sortedKeys(tagKeys(tags)) {
+		if err := setResourceTag(root, key, tags[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAgentIdentity remplace la dérivation de service.instance.id depuis
+// host.name (identique sur des VM clonées, modifiée par un renommage) par
+// l'identifiant persistant de l'agent, et ajoute le machine-id en host.id.
+// L'action insert les distingue des tags (upsert) lus par config get.
+func setAgentIdentity(root *yaml.Node, instanceID, machineID string) {
+	setResourceAttribute(root, "service.instance.id", instanceID, "insert")
+	if machineID != "" {
+		setResourceAttribute(root, "host.id", machineID, "insert")
+	}
+}
+
+// setResourceAttribute fixe la valeur statique d'un attribut du processor
+// resource avec l'action donnée ; une valeur vide supprime l'attribut
+func setResourceAttribute(root *yaml.Node, key, value, action string) {
+	resource := yamlEnsureMapping(root, "processors", "resource")
+	attributes := yamlGet(resource, "attributes")
+	if attributes == nil || attributes.Kind != yaml.SequenceNode {
+		attributes = &yaml.Node{Kind: yaml.SequenceNode}
+		yamlSet(resource, "attributes", attributes)
+	}
+
+	for i, attribute := range attributes.Content {
+		if yamlValue(yamlGet(attribute, "key")) != key {
+			continue
+		}
+		if value == "" {
+			attributes.Content = append(attributes.Content[:i], attributes.Content[i+1:]...)
+			return
+		}
+		// Une valeur statique remplace une copie depuis un autre attribut (le
+		// commentaire du modèle qui décrit cette copie devient faux)
+		if yamlDelete(attribute, "from_attribute") {
+			attribute.HeadComment = ""
+			attribute.Content[0].HeadComment = ""
+		}
+		yamlDelete(attribute, "action")
+		yamlSet(attribute, "value", yamlQuoted(value))
+		yamlSet(attribute, "action", yamlScalar(action))
+		return
+	}
+
+	if value == "" {
+		return
+	}
+	attribute := yamlMapping("key", key)
+	yamlSet(attribute, "value", yamlQuoted(value))
+	yamlSet(attribute, "action", yamlScalar(action))
+	attributes.Content = append(attributes.Content, attribute)
+
+	// Le processor doit figurer dans chaque pipeline, avant le batch
+	pipelines := yamlLookup(root, "service", "pipelines")
+	if pipelines != nil {
+		for i := 1; i < len(pipelines.Content); i += 2 {
+			insertProcessorBefore(pipelines.Content[i], "resource", "batch")
+		}
+	}
+}
+
+// readResourceTags retourne les tags statiques (action upsert) du processor resource
+func readResourceTags(root *yaml.Node) map[string]string {
+	tags := map[string]string{}
+	attributes := yamlLookup(root, "processors", "resource", "attributes")
+	if attributes == nil {
+		return tags
+	}
+	for _, attribute := range attributes.Content {
+		if yamlValue(yamlGet(attribute, "action")) == "upsert" && yamlGet(attribute, "value") != nil {
+			tags[yamlValue(yamlGet(attribute, "key"))] = yamlValue(yamlGet(attribute, "value"))
+		}
+	}
+	return tags
+}
+
+// insertProcessorBefore ajoute un processor à un pipeline, juste avant un autre
+// (ou en fin de liste), s'il n'y figure pas déjà
+func insertProcessorBefore(pipeline *yaml.Node, name, before string) {
+	processors := yamlGet(pipeline, "processors")
+	if processors == nil || processors.Kind != yaml.SequenceNode {
+		yamlAppendUnique(pipeline, "processors", name)
+		return
+	}
+	for _, item := range processors.Content {
+		if item.Value == name {
+			return
+		}
+	}
+	for i, item := range processors.Content {
+		if item.Value == before {
+			processors.Content = append(processors.Content[:i], append([]*yaml.Node{yamlScalar(name)}, processors.Content[i:]...)...)
+			return
+		}
+	}
+	processors.Content = append(processors.Content, yamlScalar(name))
+}
+
+// tagKeys convertit les tags en ensemble de clés (pour un ordre de rendu stable)
+func tagKeys(tags map[string]string) map[string]bool {
+	keys := map[string]bool{}
+	for key := range tags {
+		keys[key] = true
+	}
+	return keys
+}
+
+// enableExtension déclare une extension écoutant sur endpoint et l'active dans le service
+func enableExtension(root *yaml.Node, name, endpoint string) {
+	extension := yamlEnsureMapping(root, "extensions", name)
+	yamlSet(extension, "endpoint", yamlScalar(endpoint))
+
+	service := yamlEnsureMapping(root, "service")
+	yamlAppendUnique(service, "extensions", name)
+}
+
+// configureTelemetry expose les métriques internes du collector au format
+// Prometheus sur l'adresse locale TELEMETRY_ENDPOINT
+func configureTelemetry(root *yaml.Node) {
+	host, port, _ := net.SplitHostPort(TELEMETRY_ENDPOINT)
+
+	metrics := yamlEnsureMapping(root, "service", "telemetry", "metrics")
+	yamlDelete(metrics, "address") // forme dépréciée remplacée par readers
+
+	prometheus := yamlMapping("host", host, "port", port)
+	reader := yamlMapping()
+	yamlSet(reader, "pull", yamlMapping())
+	yamlSet(yamlEnsureMapping(reader, "pull", "exporter"), "prometheus", prometheus)
+
+	yamlSet(metrics, "readers", &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{reader}})
+}
+
+// configHasHealthCheck indique si une configuration active l'extension health_check
+func configHasHealthCheck(content []byte) bool {
+	doc, err := parseYAMLDocument(content)
+	if err != nil {
+		return false
+	}
+
+	extensions := yamlLookup(yamlRoot(doc), "service", "extensions")
+	if extensions == nil || extensions.Kind != yaml.SequenceNode {
+		return false
+	}
+	for _, item := range extensions.Content {
+		if componentType(item.Value) == "health_check" {
+			return true
+		}
+	}
+	return false
+}
+
+// describeExtensions résume les endpoints de diagnostic activés
+func describeExtensions(opts renderOptions) string {
+	description := fmt.Sprintf("health_check sur %s, télémétrie sur %s", HEALTH_CHECK_ENDPOINT, TELEMETRY_ENDPOINT)
+	if opts.EnablePprof {
+		description += ", pprof sur " + PPROF_ENDPOINT
+	}
+	if opts.EnableZPages {
+		description += ", zpages sur " + ZPAGES_ENDPOINT
+	}
+	return description
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// Sous-répertoires du répertoire d'état : dernière configuration avec
+	// laquelle le collector a démarré correctement, et configurations rejetées
+	LAST_KNOWN_GOOD_DIR = "last-known-good"
+	FAILED_CONFIG_DIR   = "failed"
+)
+
+// rollbackFiles retourne les fichiers de configuration couverts par le retour
+// arrière : config.yaml et agent.env (endpoint et jeton)
+func rollbackFiles() ([]string, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	envPath, err := getEnvFilePath()
+	if err != nil {
+		return nil, err
+	}
+	return []string{configPath, envPath}, nil
+}
+
+// lastKnownGoodPath retourne le chemin de la copie de référence d'un fichier
+func lastKnownGoodPath(path string) (string, error) {
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, LAST_KNOWN_GOOD_DIR, filepath.Base(path)), nil
+}
+
+// hasLastKnownGood indique si une dernière configuration valide est enregistrée
+func hasLastKnownGood() bool {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return false
+	}
+	saved, err := lastKnownGoodPath(configPath)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(saved)
+	return err == nil
+}
+
+// prepareConfigRollback est appelé avant toute modification de la
+// configuration : si aucune référence n'existe encore (installation antérieure
+// au retour arrière) et que le service tourne, la configuration actuelle devient
+// la dernière configuration valide
+func prepareConfigRollback() {
+	if hasLastKnownGood() || !agentServiceActive() {
+		return
+	}
+	if err := saveLastKnownGood(); err != nil {
+		slog.Warn("Impossible d'enregistrer la configuration actuelle comme référence", "error", err)
+	}
+}
+
+// saveLastKnownGood copie la configuration en service dans le répertoire d'état
+func saveLastKnownGood() error {
+	files, err := rollbackFiles()
+	if err != nil {
+		return err
+	}
+	dir, err := ensureStateDirectory(LAST_KNOWN_GOOD_DIR)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		saved := filepath.Join(dir, filepath.Base(path))
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			os.Remove(saved)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeBytesAtomic(saved, content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreLastKnownGood réinstalle la dernière configuration valide ; le mode
+// de chaque fichier est conservé (config.yaml lisible, agent.env privé)
+func restoreLastKnownGood() error {
+	files, err := rollbackFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		saved, err := lastKnownGoodPath(path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(saved)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := writeBytesAtomic(path, content, mode); err != nil {
+			return fmt.Errorf("impossible de restaurer %s : %w", path, err)
+		}
+	}
+	return nil
+}
+
+// configMatchesLastKnownGood indique si la configuration en place est déjà
+// la dernière configuration valide (un retour arrière n'apporterait rien)
+func configMatchesLastKnownGood() bool {
+	files, err := rollbackFiles()
+	if err != nil {
+		return false
+	}
+	for _, path := range files {
+		saved, err := lastKnownGoodPath(path)
+		if err != nil {
+			return false
+		}
+		current, currentErr := os.ReadFile(path)
+		previous, previousErr := os.ReadFile(saved)
+		if os.IsNotExist(currentErr) && os.IsNotExist(previousErr) {
+			continue
+		}
+		if currentErr != nil || previousErr != nil || !bytes.Equal(current, previous) {
+			return false
+		}
+	}
+	return true
+}
+
+// saveFailedConfig conserve la configuration rejetée pour analyse
+func saveFailedConfig() (string, error) {
+	files, err := rollbackFiles()
+	if err != nil {
+		return "", err
+	}
+	dir, err := ensureStateDirectory(FAILED_CONFIG_DIR, time.Now().Format("20060102-150405"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range files {
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(path)), content, 0600); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// restartWithRollback redémarre le service avec la nouvelle configuration. Si
+// le collector n'est pas opérationnel à temps, la dernière configuration
+// valide est restaurée et le service redémarré ; l'erreur d'origine est
+// toujours retournée pour que la commande se termine en échec
+func restartWithRollback(restart func() error) error {
+	err := restart()
+	if err == nil {
+		if err := saveLastKnownGood(); err != nil {
+			slog.Warn("Impossible d'enregistrer la dernière configuration valide", "error", err)
+		}
+		return nil
+	}
+
+	if !hasLastKnownGood() {
+		return fmt.Errorf("%w (aucune configuration valide précédente à restaurer)", err)
+	}
+	if configMatchesLastKnownGood() {
+		return fmt.Errorf("%w (la configuration n'a pas changé depuis le dernier démarrage réussi)", err)
+	}
+
+	slog.Warn("Retour à la dernière configuration valide", "error", err)
+	failedDir, saveErr := saveFailedConfig()
+	if saveErr != nil {
+		slog.Warn("Impossible de conserver la configuration en échec", "error", saveErr)
+	}
+	if restoreErr := restoreLastKnownGood(); restoreErr != nil {
+		return fmt.Errorf("%w ; retour arrière impossible : %v", err, restoreErr)
+	}
+	if restartErr := restart(); restartErr != nil {
+		return fmt.Errorf("%w ; échec du redémarrage avec la configuration restaurée : %v", err, restartErr)
+	}
+
+	slog.Info("Service redémarré avec la dernière configuration valide", "failed_config", failedDir)
+	if saveErr != nil {
+		return fmt.Errorf("%w ; dernière configuration valide restaurée", err)
+	}
+	return fmt.Errorf("%w ; dernière configuration valide restaurée, configuration en échec conservée dans %s", err, failedDir)
+}
+
+// agentServiceActive indique si le service de l'agent est en cours d'exécution
+func agentServiceActive() bool {
+	switch runtime.GOOS {
+	case "linux":
+		return isLinuxServiceActive()
+	case "windows":
+		output, err := commandOutput("sc", "query", SERVICE_NAME)
+		return err == nil && strings.Contains(output, "RUNNING")
+	default:
+		return false
+	}
+}
@@ -2,10 +2,13 @@
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // installLinuxService stub pour macOS - la vraie implémentation est dans service_linux.go
-func installLinuxService() error {
+func installLinuxService(opts installOptions) error {
 	return fmt.Errorf("installLinuxService n'est pas supporté sur macOS")
 }
 
@@ -13,3 +16,28 @@ func installLinuxService() error {
 func installWindowsService() error {
 	return fmt.Errorf("installWindowsService n'est pas supporté sur macOS")
 }
+
+// restartLinuxService stub pour macOS - la vraie implémentation est dans service_linux.go
+func restartLinuxService(timeout time.Duration, journalLines int) error {
+	return fmt.Errorf("restartLinuxService n'est pas supporté sur macOS")
+}
+
+// restartWindowsService stub pour macOS - la vraie implémentation est dans service_windows.go
+func restartWindowsService() error {
+	return fmt.Errorf("restartWindowsService n'est pas supporté sur macOS")
+}
+
+// reloadLinuxService stub pour macOS - la vraie implémentation est dans servicemanager_linux.go
+func reloadLinuxService(timeout time.Duration, journalLines int) error {
+	return fmt.Errorf("reloadLinuxService n'est pas supporté sur macOS")
+}
+
+// isLinuxServiceActive stub pour macOS - la vraie implémentation est dans servicemanager_linux.go
+func isLinuxServiceActive() bool {
+	return false
+}
+
+// runPackageHook stub pour macOS - la vraie implémentation est dans package_hooks_linux.go
+func runPackageHook(args []string) error {
+	return fmt.Errorf("les paquets .deb et .rpm ne sont pris en charge que sous Linux")
+}
@@ -4,134 +4,316 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
-	"os/exec"
-	"runtime"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// installLinuxService installe et configure le service systemd sur Linux
-func installLinuxService() error {
-	if runtime.GOOS != "linux" {
-		return fmt.Errorf("cette fonction ne fonctionne que sur Linux")
-	}
+const (
+	// Nombre de redémarrages automatiques pendant l'attente au-delà duquel on
+	// considère que le collector est en boucle de crash
+	MAX_RESTARTS_DURING_WAIT = 2
 
-	fmt.Println("🔧 Installation du service systemd...")
+	// Sans health_check, durée pendant laquelle l'unité doit rester "running"
+	// pour être considérée stable
+	READY_STABLE_PERIOD = 10 * time.Second
 
-	// Créer l'utilisateur système
-	if err := createSystemUser(); err != nil {
-		return fmt.Errorf("échec création utilisateur : %w", err)
-	}
+	// Délai laissé au collector pour arrêter puis relancer ses composants
+	// après SIGHUP, avant de vérifier son état
+	RELOAD_SETTLE_DELAY = 2 * time.Second
+)
+
+// systemdManager gère le collector comme une unité systemd
+type systemdManager struct {
+	wait serviceWait
+}
 
-	// Créer le répertoire de logs
-	if err := createLogDirectory(); err != nil {
-		return fmt.Errorf("échec création répertoire logs : %w", err)
+func (m *systemdManager) Name() string { return SERVICE_MANAGER_SYSTEMD }
+
+// Install prépare le compte de service, écrit l'unité et l'active au démarrage
+func (m *systemdManager) Install(opts installOptions) error {
+	slog.Info("Installation du service systemd")
+
+	if err := prepareServiceAccount(); err != nil {
+		return err
 	}
 
 	// Copier le fichier service systemd
-	if err := installSystemdServiceFile(); err != nil {
+	if err := installSystemdServiceFile(opts); err != nil {
 		return fmt.Errorf("échec installation fichier service : %w", err)
 	}
 
 	// Recharger systemd pour prendre en compte le nouveau service
-	fmt.Println("🔄 Rechargement de systemd...")
-	if err := runSystemCommand("systemctl", "daemon-reload"); err != nil {
+	slog.Info("Rechargement de systemd")
+	if err := runSystemCommand("systemctl", systemctlArgs("daemon-reload")...); err != nil {
 		return fmt.Errorf("échec rechargement systemd : %w", err)
 	}
 
 	// Activer le service pour démarrage automatique
-	fmt.Println("✅ Activation du service au démarrage...")
-	if err := runSystemCommand("systemctl", "enable", SERVICE_NAME); err != nil {
+	slog.Info("Activation du service au démarrage")
+	if err := runSystemCommand("systemctl", systemctlArgs("enable", SERVICE_NAME)...); err != nil {
 		return fmt.Errorf("échec activation service : %w", err)
 	}
 
-	// Démarrer le service
-	fmt.Println("🚀 Démarrage du service...")
-	if err := runSystemCommand("systemctl", "start", SERVICE_NAME); err != nil {
+	// Une unité --user ne tourne que pendant les sessions de l'utilisateur, sauf lingering
+	if userMode {
+		enableLinger()
+	}
+	return nil
+}
+
+// Start redémarre le service puis attend que le collector soit opérationnel ;
+// le journal du démarrage est affiché en cas d'échec
+func (m *systemdManager) Start() error {
+	startedAt := time.Now()
+	if err := runSystemCommand("systemctl", systemctlArgs("restart", SERVICE_NAME)...); err != nil {
+		logLinuxJournalExcerpt(startedAt, m.wait.JournalLines)
 		return fmt.Errorf("échec démarrage service : %w", err)
 	}
 
-	// Vérifier que le service fonctionne
-	if err := checkLinuxServiceStatus(); err != nil {
+	if err := checkLinuxServiceStatus(m.wait.ReadyTimeout); err != nil {
+		logLinuxJournalExcerpt(startedAt, m.wait.JournalLines)
 		return fmt.Errorf("le service ne semble pas fonctionner : %w", err)
 	}
+	return nil
+}
+
+// CanReload indique si l'unité installée définit ExecReload (unités générées
+// avant la prise en charge du rechargement : redémarrage nécessaire)
+func (m *systemdManager) CanReload() bool {
+	output, err := commandOutput("systemctl", systemctlArgs("show", SERVICE_NAME, "--property=CanReload")...)
+	return err == nil && parseSystemdProperties(output)["CanReload"] == "yes"
+}
+
+// Reload fait relire sa configuration au collector (SIGHUP) puis attend qu'il
+// soit de nouveau opérationnel ; un changement de PID signifie que le
+// collector s'est arrêté au lieu de recharger
+func (m *systemdManager) Reload() error {
+	before := readUnitStatus()
+	startedAt := time.Now()
+	if err := runSystemCommand("systemctl", systemctlArgs("reload", SERVICE_NAME)...); err != nil {
+		logLinuxJournalExcerpt(startedAt, m.wait.JournalLines)
+		return fmt.Errorf("échec du rechargement : %w", err)
+	}
 
-	fmt.Println("✅ Service systemd installé et démarré avec succès")
+	time.Sleep(RELOAD_SETTLE_DELAY)
+	if after := readUnitStatus(); after.MainPID != before.MainPID {
+		logLinuxJournalExcerpt(startedAt, m.wait.JournalLines)
+		return fmt.Errorf("le collector s'est arrêté pendant le rechargement (PID %d, puis %d)", before.MainPID, after.MainPID)
+	}
+	if err := checkLinuxServiceStatus(m.wait.ReadyTimeout); err != nil {
+		logLinuxJournalExcerpt(startedAt, m.wait.JournalLines)
+		return fmt.Errorf("le service ne semble pas fonctionner après rechargement : %w", err)
+	}
 	return nil
 }
 
-// installSystemdServiceFile crée le fichier .service systemd
-func installSystemdServiceFile() error {
-	serviceContent := `[Unit]
-Description=SmartSentry Observability Agent
-Documentation=https://github.com/Arceuid731/smartsentry-agent
-After=network.target
-Wants=network.target
-
-[Service]
-Type=simple
-User=smartsentry
-Group=smartsentry
-ExecStart=/usr/local/bin/otelcol-contrib --config=/etc/smartsentry-agent/config.yaml
-Restart=always
-RestartSec=5
-
-# Sécurité renforcée
-NoNewPrivileges=true
-ProtectSystem=strict
-ProtectHome=true
-ReadWritePaths=/var/log/smartsentry-agent
-
-# Logging
-StandardOutput=journal
-StandardError=journal
-SyslogIdentifier=smartsentry-agent
-
-[Install]
-WantedBy=multi-user.target
-`
-
-	servicePath := "/etc/systemd/system/" + SERVICE_NAME + ".service"
-	fmt.Printf("📝 Création du fichier service : %s\n", servicePath)
+func (m *systemdManager) Stop() error {
+	return runSystemCommand("systemctl", systemctlArgs("stop", SERVICE_NAME)...)
+}
 
-	// Écrire le fichier service
-	err := os.WriteFile(servicePath, []byte(serviceContent), 0644)
+func (m *systemdManager) Status() (serviceState, error) {
+	state, err := commandOutput("systemctl", systemctlArgs("is-active", SERVICE_NAME)...)
+	if state == "" && err != nil {
+		return serviceState{}, err
+	}
+	return serviceState{Active: state == "active", State: state}, nil
+}
+
+// Uninstall désactive le service et retire l'unité et le drop-in de l'installateur
+func (m *systemdManager) Uninstall() error {
+	if err := runSystemCommand("systemctl", systemctlArgs("disable", "--now", SERVICE_NAME)...); err != nil {
+		slog.Warn("Impossible de désactiver le service", "error", err)
+	}
+	for _, path := range []string{systemdUnitPath(), filepath.Join(systemdDropInDir(), SYSTEMD_DROPIN_NAME)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return runSystemCommand("systemctl", systemctlArgs("daemon-reload")...)
+}
+
+// installSystemdServiceFile génère l'unité systemd depuis le modèle embarqué
+// et, si des limites de ressources sont demandées, le drop-in correspondant
+func installSystemdServiceFile(opts installOptions) error {
+	params := defaultUnitParams()
+	params.Hardened = opts.Hardened && !userMode
+
+	if envPath, err := getEnvFilePath(); err == nil {
+		if _, err := os.Stat(envPath); err == nil {
+			params.EnvironmentFile = envPath
+		}
+	}
+
+	// Accorder uniquement les droits requis par les receivers de la configuration installée
+	config, err := os.ReadFile(params.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("impossible de lire %s : %w", params.ConfigPath, err)
+	}
+	requirements, err := analyzePrivileges(config)
 	if err != nil {
+		return fmt.Errorf("impossible d'analyser la configuration : %w", err)
+	}
+	printPrivilegeRequirements(requirements)
+	if opts.Runtime == RUNTIME_CONTAINER {
+		// Les droits ciblés ne traversent pas le moteur : le conteneur tourne
+		// en root (lecture seule, sans élévation) si des receivers en requièrent
+		capabilities, groups := mergePrivileges(requirements)
+		params.ContainerEngine = opts.ContainerEngine
+		params.ContainerArgs, err = containerRunArgs(params.ConfigPath, config, len(capabilities)+len(groups) > 0)
+		if err != nil {
+			return fmt.Errorf("impossible d'analyser la configuration : %w", err)
+		}
+		params.Hardened = false
+	} else if !userMode {
+		// Une unité --user ne peut pas obtenir de droits supplémentaires
+		params.AmbientCapabilities, params.SupplementaryGroups = mergePrivileges(requirements)
+		params.SupplementaryGroups = existingGroups(params.SupplementaryGroups)
+	}
+	params.MemoryMax = opts.MemoryMax
+	params.CPUQuota = opts.CPUQuota
+	params.LimitNOFILE = opts.LimitNOFILE
+
+	serviceContent, err := renderSystemdUnit(params)
+	if err != nil {
+		return fmt.Errorf("impossible de générer l'unité systemd : %w", err)
+	}
+
+	servicePath := systemdUnitPath()
+	slog.Info("Création du fichier service", "path", servicePath)
+
+	// ~/.config/systemd/user n'existe pas forcément pour une unité --user
+	if err := os.MkdirAll(filepath.Dir(servicePath), 0755); err != nil {
+		return fmt.Errorf("impossible de créer %s : %w", filepath.Dir(servicePath), err)
+	}
+
+	// Écrire le fichier service
+	if err := writeBytesAtomic(servicePath, serviceContent, 0644); err != nil {
 		return fmt.Errorf("impossible d'écrire %s : %w", servicePath, err)
 	}
 
-	return nil
-}
+	// Sans nouvelle option de limites, le drop-in existant (réglage local) est conservé
+	if !params.hasResourceLimits() {
+		return nil
+	}
 
-// checkLinuxServiceStatus vérifie que le service systemd fonctionne correctement
-func checkLinuxServiceStatus() error {
-	fmt.Println("🔍 Vérification du statut du service...")
+	dropInDir := systemdDropInDir()
+	if err := os.MkdirAll(dropInDir, 0755); err != nil {
+		return fmt.Errorf("impossible de créer %s : %w", dropInDir, err)
+	}
 
-	// Exécuter systemctl status
-	cmd := exec.Command("systemctl", "is-active", SERVICE_NAME)
-	output, err := cmd.Output()
+	dropInContent, err := renderSystemdDropIn(params)
+	if err != nil {
+		return fmt.Errorf("impossible de générer le drop-in systemd : %w", err)
+	}
 
-	status := strings.TrimSpace(string(output))
-	if err != nil || status != "active" {
-		return fmt.Errorf("service non actif (statut: %s)", status)
+	dropInPath := filepath.Join(dropInDir, SYSTEMD_DROPIN_NAME)
+	slog.Info("Limites de ressources enregistrées", "path", dropInPath)
+	if err := writeBytesAtomic(dropInPath, dropInContent, 0644); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", dropInPath, err)
 	}
 
-	fmt.Printf("✅ Service %s actif et en cours d'exécution\n", SERVICE_NAME)
 	return nil
 }
 
-// stopLinuxService arrête le service systemd
-func stopLinuxService() error {
-	if runtime.GOOS != "linux" {
-		return nil
+// existingGroups filtre les groupes absents du système (ex: adm n'existe pas
+// sur toutes les distributions), systemd refusant de démarrer sinon
+func existingGroups(groups []string) []string {
+	var existing []string
+	for _, group := range groups {
+		if err := runSystemCommand("getent", "group", group); err != nil {
+			slog.Warn("Groupe absent du système : les journaux concernés risquent d'être illisibles", "group", group)
+			continue
+		}
+		existing = append(existing, group)
+	}
+	return existing
+}
+
+// checkLinuxServiceStatus attend que le collector soit réellement opérationnel :
+// avec Type=simple, l'unité est "active" dès le lancement du processus, même si
+// le collector s'arrête une seconde plus tard sur une configuration invalide.
+// Une boucle de redémarrages (NRestarts qui augmente) est détectée sans
+// attendre la fin du délai.
+func checkLinuxServiceStatus(timeout time.Duration) error {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
 	}
+	content, err := os.ReadFile(configPath)
+	useHealthCheck := err == nil && configHasHealthCheck(content)
 
-	fmt.Printf("🛑 Arrêt du service %s...\n", SERVICE_NAME)
+	if useHealthCheck {
+		slog.Info("Attente du health_check", "endpoint", HEALTH_CHECK_ENDPOINT, "timeout", timeout)
+	} else {
+		// Configuration conservée d'une installation précédente sans health_check
+		slog.Warn("Extension health_check absente de la configuration : attente d'un fonctionnement stable", "period", READY_STABLE_PERIOD)
+	}
 
-	// Arrêter le service
-	if err := runSystemCommand("systemctl", "stop", SERVICE_NAME); err != nil {
-		fmt.Printf("⚠️  Attention : impossible d'arrêter le service : %v\n", err)
+	baseline := readUnitStatus()
+	if baseline.Error != "" {
+		return fmt.Errorf("état du service illisible : %s", baseline.Error)
 	}
 
-	return nil
+	deadline := time.Now().Add(timeout)
+	var stableSince time.Time
+	for {
+		unit := readUnitStatus()
+
+		if restarts := unit.Restarts - baseline.Restarts; restarts >= MAX_RESTARTS_DURING_WAIT {
+			return fmt.Errorf("boucle de redémarrage détectée : %d redémarrages automatiques depuis le démarrage", restarts)
+		}
+
+		// Inutile d'attendre si systemd a déjà abandonné le service
+		if unit.ActiveState == "failed" || unit.ActiveState == "inactive" {
+			return fmt.Errorf("service non actif (statut: %s/%s)", unit.ActiveState, unit.SubState)
+		}
+
+		var health healthStatus
+		if useHealthCheck {
+			health = probeHealth()
+			if health.Healthy {
+				slog.Info("Service actif et collector opérationnel", "service", SERVICE_NAME, "health", health.Status)
+				return nil
+			}
+		} else if unit.ActiveState == "active" && unit.SubState == "running" {
+			if stableSince.IsZero() {
+				stableSince = time.Now()
+			} else if time.Since(stableSince) >= READY_STABLE_PERIOD {
+				slog.Info("Service actif et stable", "service", SERVICE_NAME, "period", READY_STABLE_PERIOD)
+				return nil
+			}
+		} else {
+			stableSince = time.Time{}
+		}
+
+		if time.Now().After(deadline) {
+			reason := health.Error
+			if reason == "" {
+				reason = health.Status
+			}
+			if !useHealthCheck {
+				reason = "non vérifié"
+			}
+			return fmt.Errorf("collector non opérationnel après %s (statut: %s/%s, health_check: %s)",
+				timeout, unit.ActiveState, unit.SubState, reason)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// logLinuxJournalExcerpt journalise les dernières lignes du journal du service
+// depuis son démarrage, avec la cause probable de l'échec
+func logLinuxJournalExcerpt(since time.Time, lines int) {
+	journal, err := commandOutput("journalctl", append(journalctlUnitArgs(),
+		"--since", since.Format("2006-01-02 15:04:05"),
+		"-n", strconv.Itoa(lines), "--no-pager", "-o", "cat")...)
+	if err != nil || journal == "" {
+		slog.Warn("Journal indisponible", "command", "journalctl "+strings.Join(journalctlUnitArgs(), " ")+" -e")
+		return
+	}
+	logJournalExcerpt(journal)
 }
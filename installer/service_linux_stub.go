@@ -8,3 +8,8 @@ import "fmt"
 func installWindowsService() error {
 	return fmt.Errorf("installWindowsService n'est pas supporté sur Linux")
 }
+
+// restartWindowsService stub pour Linux - la vraie implémentation est dans service_windows.go
+func restartWindowsService() error {
+	return fmt.Errorf("restartWindowsService n'est pas supporté sur Linux")
+}
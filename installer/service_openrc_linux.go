@@ -0,0 +1,85 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// openrcManager gère le collector comme un service OpenRC (Alpine, Gentoo),
+// supervisé par supervise-daemon
+type openrcManager struct {
+	wait serviceWait
+}
+
+func (m *openrcManager) Name() string { return SERVICE_MANAGER_OPENRC }
+
+// Install prépare le compte de service, écrit le script et l'ajoute au runlevel default
+func (m *openrcManager) Install(opts installOptions) error {
+	slog.Info("Installation du service OpenRC")
+
+	if err := prepareServiceAccount(); err != nil {
+		return err
+	}
+	params, err := initScriptParams()
+	if err != nil {
+		return err
+	}
+	if err := writeInitScript("openrc", params); err != nil {
+		return err
+	}
+
+	slog.Info("Activation du service au démarrage")
+	if err := runSystemCommand("rc-update", "add", SERVICE_NAME, "default"); err != nil {
+		return fmt.Errorf("échec activation service : %w", err)
+	}
+	return nil
+}
+
+func (m *openrcManager) Start() error {
+	if err := runSystemCommand("rc-service", SERVICE_NAME, "restart"); err != nil {
+		logLogFileExcerpt(m.wait.JournalLines)
+		return fmt.Errorf("échec démarrage service : %w", err)
+	}
+
+	// supervise-daemon relance le collector : laisser passer un éventuel crash immédiat
+	time.Sleep(2 * time.Second)
+	running := func() bool {
+		state, err := m.Status()
+		return err == nil && state.Active
+	}
+	if err := waitForCollector(m.wait.ReadyTimeout, running); err != nil {
+		logLogFileExcerpt(m.wait.JournalLines)
+		return fmt.Errorf("le service ne semble pas fonctionner : %w", err)
+	}
+	return nil
+}
+
+func (m *openrcManager) Stop() error {
+	return runSystemCommand("rc-service", SERVICE_NAME, "stop")
+}
+
+// Status interprète "rc-service <nom> status" (code 0 si démarré)
+func (m *openrcManager) Status() (serviceState, error) {
+	output, err := commandOutput("rc-service", SERVICE_NAME, "status")
+	if output == "" && err != nil {
+		return serviceState{}, err
+	}
+	return serviceState{Active: err == nil, State: output}, nil
+}
+
+func (m *openrcManager) Uninstall() error {
+	if err := m.Stop(); err != nil {
+		slog.Warn("Impossible d'arrêter le service", "error", err)
+	}
+	if err := runSystemCommand("rc-update", "del", SERVICE_NAME, "default"); err != nil {
+		slog.Warn("Impossible de désactiver le service", "error", err)
+	}
+	if err := os.Remove(initScriptPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// sysvManager gère le collector avec un script d'init System V (LSB) ; ce
+// système n'ayant pas de supervision, le collector n'est pas relancé s'il s'arrête
+type sysvManager struct {
+	wait serviceWait
+}
+
+func (m *sysvManager) Name() string { return SERVICE_MANAGER_SYSV }
+
+// Install prépare le compte de service, écrit le script et l'active dans les runlevels
+func (m *sysvManager) Install(opts installOptions) error {
+	slog.Info("Installation du script d'init System V")
+
+	if err := prepareServiceAccount(); err != nil {
+		return err
+	}
+	params, err := initScriptParams()
+	if err != nil {
+		return err
+	}
+	if err := writeInitScript("sysv", params); err != nil {
+		return err
+	}
+
+	// update-rc.d (Debian) ou chkconfig (Red Hat) selon la distribution
+	slog.Info("Activation du service au démarrage")
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		err = runSystemCommand("update-rc.d", SERVICE_NAME, "defaults")
+		if err != nil {
+			return fmt.Errorf("échec activation service : %w", err)
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("chkconfig"); err == nil {
+		if err := runSystemCommand("chkconfig", "--add", SERVICE_NAME); err != nil {
+			return fmt.Errorf("échec activation service : %w", err)
+		}
+		return runSystemCommand("chkconfig", SERVICE_NAME, "on")
+	}
+	slog.Warn("Ni update-rc.d ni chkconfig : liez le script dans les runlevels manuellement", "path", initScriptPath())
+	return nil
+}
+
+func (m *sysvManager) Start() error {
+	if err := runSystemCommand(initScriptPath(), "restart"); err != nil {
+		logLogFileExcerpt(m.wait.JournalLines)
+		return fmt.Errorf("échec démarrage service : %w", err)
+	}
+
+	running := func() bool {
+		state, err := m.Status()
+		return err == nil && state.Active
+	}
+	if err := waitForCollector(m.wait.ReadyTimeout, running); err != nil {
+		logLogFileExcerpt(m.wait.JournalLines)
+		return fmt.Errorf("le service ne semble pas fonctionner : %w", err)
+	}
+	return nil
+}
+
+func (m *sysvManager) Stop() error {
+	return runSystemCommand(initScriptPath(), "stop")
+}
+
+// Status interprète "<script> status" (code 0 si démarré, 3 sinon selon LSB)
+func (m *sysvManager) Status() (serviceState, error) {
+	if _, err := os.Stat(initScriptPath()); err != nil {
+		return serviceState{}, err
+	}
+	output, err := commandOutput(initScriptPath(), "status")
+	return serviceState{Active: err == nil, State: output}, nil
+}
+
+func (m *sysvManager) Uninstall() error {
+	if err := m.Stop(); err != nil {
+		slog.Warn("Impossible d'arrêter le service", "error", err)
+	}
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		runSystemCommand("update-rc.d", "-f", SERVICE_NAME, "remove")
+	} else if _, err := exec.LookPath("chkconfig"); err == nil {
+		runSystemCommand("chkconfig", "--del", SERVICE_NAME)
+	}
+	if err := os.Remove(initScriptPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
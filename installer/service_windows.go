@@ -4,8 +4,8 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strings"
 )
@@ -16,7 +16,7 @@ func installWindowsService() error {
 		return fmt.Errorf("cette fonction ne fonctionne que sur Windows")
 	}
 
-	fmt.Println("🔧 Installation du service Windows...")
+	slog.Info("Installation du service Windows")
 
 	// Créer le répertoire de logs
 	if err := createLogDirectory(); err != nil {
@@ -24,12 +24,11 @@ func installWindowsService() error {
 	}
 
 	// Chemin vers le binaire otelcol-contrib
-	binaryPath := `C:\Program Files\SmartSentry\otelcol-contrib.exe`
-	configPath, err := getConfigDirectory()
+	binaryPath := getBinaryPath()
+	configFile, err := getConfigPath()
 	if err != nil {
 		return fmt.Errorf("impossible de déterminer le répertoire de config : %w", err)
 	}
-	configFile := filepath.Join(configPath, "config.yaml")
 
 	// Commande pour créer le service Windows
 	// sc create : crée un nouveau service
@@ -40,7 +39,7 @@ func installWindowsService() error {
 		configFile,
 	)
 
-	fmt.Println("📝 Création du service Windows...")
+	slog.Info("Création du service Windows", "service", SERVICE_NAME)
 	if err := runWindowsCommand(serviceCmd); err != nil {
 		return fmt.Errorf("échec création service Windows : %w", err)
 	}
@@ -53,7 +52,7 @@ func installWindowsService() error {
 	runWindowsCommand(descCmd) // Ignorer l'erreur, c'est optionnel
 
 	// Démarrer le service
-	fmt.Println("🚀 Démarrage du service...")
+	slog.Info("Démarrage du service", "service", SERVICE_NAME)
 	if err := runWindowsCommand(fmt.Sprintf(`sc start "%s"`, SERVICE_NAME)); err != nil {
 		return fmt.Errorf("échec démarrage service : %w", err)
 	}
@@ -63,17 +62,18 @@ func installWindowsService() error {
 		return fmt.Errorf("le service ne semble pas fonctionner : %w", err)
 	}
 
-	fmt.Println("✅ Service Windows installé et démarré avec succès")
+	slog.Info("Service Windows installé et démarré")
 	return nil
 }
 
 // checkWindowsServiceStatus vérifie que le service Windows fonctionne
 func checkWindowsServiceStatus() error {
-	fmt.Println("🔍 Vérification du statut du service...")
+	slog.Info("Vérification du statut du service", "service", SERVICE_NAME)
 
 	// Utiliser sc query pour vérifier le statut
 	cmd := exec.Command("sc", "query", SERVICE_NAME)
 	output, err := cmd.Output()
+	logCommand("sc", cmd.Args[1:], err, output)
 
 	if err != nil {
 		return fmt.Errorf("impossible de vérifier le statut du service : %w", err)
@@ -83,7 +83,7 @@ func checkWindowsServiceStatus() error {
 
 	// Chercher "RUNNING" dans la sortie
 	if strings.Contains(outputStr, "RUNNING") {
-		fmt.Printf("✅ Service %s actif et en cours d'exécution\n", SERVICE_NAME)
+		slog.Info("Service actif et en cours d'exécution", "service", SERVICE_NAME)
 		return nil
 	} else if strings.Contains(outputStr, "STOPPED") {
 		return fmt.Errorf("service arrêté")
@@ -100,23 +100,37 @@ func stopWindowsService() error {
 		return nil
 	}
 
-	fmt.Printf("🛑 Arrêt du service %s...\n", SERVICE_NAME)
+	slog.Info("Arrêt du service", "service", SERVICE_NAME)
 
 	cmd := fmt.Sprintf(`sc stop "%s"`, SERVICE_NAME)
 	if err := runWindowsCommand(cmd); err != nil {
-		fmt.Printf("⚠️  Attention : impossible d'arrêter le service : %v\n", err)
+		slog.Warn("Impossible d'arrêter le service", "error", err)
 	}
 
 	return nil
 }
 
+// restartWindowsService redémarre le service Windows ; net stop/start
+// attendent la fin de l'opération, contrairement à sc
+func restartWindowsService() error {
+	slog.Info("Redémarrage du service", "service", SERVICE_NAME)
+
+	if err := runWindowsCommand(fmt.Sprintf(`net stop "%s"`, SERVICE_NAME)); err != nil {
+		slog.Warn("Impossible d'arrêter le service", "error", err)
+	}
+	if err := runWindowsCommand(fmt.Sprintf(`net start "%s"`, SERVICE_NAME)); err != nil {
+		return fmt.Errorf("échec démarrage service : %w", err)
+	}
+	return checkWindowsServiceStatus()
+}
+
 // uninstallWindowsService désinstalle complètement le service Windows
 func uninstallWindowsService() error {
 	if runtime.GOOS != "windows" {
 		return nil
 	}
 
-	fmt.Printf("🗑️  Désinstallation du service %s...\n", SERVICE_NAME)
+	slog.Info("Désinstallation du service", "service", SERVICE_NAME)
 
 	// Arrêter le service
 	stopWindowsService()
@@ -124,10 +138,10 @@ func uninstallWindowsService() error {
 	// Supprimer le service
 	cmd := fmt.Sprintf(`sc delete "%s"`, SERVICE_NAME)
 	if err := runWindowsCommand(cmd); err != nil {
-		fmt.Printf("⚠️  Attention : impossible de supprimer le service : %v\n", err)
+		slog.Warn("Impossible de supprimer le service", "error", err)
 	}
 
-	fmt.Println("✅ Service désinstallé")
+	slog.Info("Service désinstallé", "service", SERVICE_NAME)
 	return nil
 }
 
@@ -136,11 +150,12 @@ func runWindowsCommand(command string) error {
 	cmd := exec.Command("cmd", "/C", command)
 
 	output, err := cmd.CombinedOutput()
+	logCommand("cmd", cmd.Args[1:], err, output)
 
 	if err != nil {
 		// Afficher la sortie en cas d'erreur pour diagnostiquer
 		if len(output) > 0 {
-			fmt.Printf("Erreur commande '%s': %s\n", command, string(output))
+			slog.Warn("Commande en échec", "command", command, "output", strings.TrimSpace(string(output)))
 		}
 		return fmt.Errorf("commande '%s' échouée : %w", command, err)
 	}
@@ -2,9 +2,32 @@
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // installLinuxService stub pour Windows - la vraie implémentation est dans service_linux.go
-func installLinuxService() error {
+func installLinuxService(opts installOptions) error {
 	return fmt.Errorf("installLinuxService n'est pas supporté sur Windows")
 }
+
+// restartLinuxService stub pour Windows - la vraie implémentation est dans service_linux.go
+func restartLinuxService(timeout time.Duration, journalLines int) error {
+	return fmt.Errorf("restartLinuxService n'est pas supporté sur Windows")
+}
+
+// reloadLinuxService stub pour Windows - la vraie implémentation est dans servicemanager_linux.go
+func reloadLinuxService(timeout time.Duration, journalLines int) error {
+	return fmt.Errorf("reloadLinuxService n'est pas supporté sur Windows")
+}
+
+// isLinuxServiceActive stub pour Windows - la vraie implémentation est dans servicemanager_linux.go
+func isLinuxServiceActive() bool {
+	return false
+}
+
+// runPackageHook stub pour Windows - la vraie implémentation est dans package_hooks_linux.go
+func runPackageHook(args []string) error {
+	return fmt.Errorf("les paquets .deb et .rpm ne sont pris en charge que sous Linux")
+}
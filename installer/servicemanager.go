@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Gestionnaires de service disponibles (--service-manager)
+	SERVICE_MANAGER_AUTO    = "auto"
+	SERVICE_MANAGER_SYSTEMD = "systemd"
+	SERVICE_MANAGER_OPENRC  = "openrc"
+	SERVICE_MANAGER_SYSV    = "sysv"
+	SERVICE_MANAGER_NONE    = "none" // fichiers uniquement (conteneurs, supervision externe)
+
+	// Fichier du répertoire d'état mémorisant le gestionnaire utilisé à l'installation
+	SERVICE_MANAGER_STATE_FILE = "service-manager"
+
+	// Journal du collector lorsque le gestionnaire ne fournit pas de journal (OpenRC, SysV)
+	COLLECTOR_LOG_FILE = "/var/log/smartsentry-agent/agent.log"
+)
+
+// ServiceManager abstrait le système d'init qui supervise le collector
+type ServiceManager interface {
+	// Name retourne le nom du gestionnaire (systemd, openrc, sysv, none)
+	Name() string
+
+	// Install dépose les fichiers de service et active le démarrage automatique
+	Install(opts installOptions) error
+
+	// Start (re)démarre le service et attend que le collector soit opérationnel
+	Start() error
+
+	// Stop arrête le service
+	Stop() error
+
+	// Status retourne l'état courant du service
+	Status() (serviceState, error)
+
+	// Uninstall arrête le service et retire ses fichiers
+	Uninstall() error
+}
+
+// serviceState décrit l'état d'un service
+type serviceState struct {
+	Active bool
+	State  string
+}
+
+// serviceWait regroupe les paramètres d'attente de disponibilité après démarrage
+type serviceWait struct {
+	ReadyTimeout time.Duration
+	JournalLines int
+}
+
+// validServiceManager indique si un gestionnaire de service est reconnu
+func validServiceManager(name string) bool {
+	switch name {
+	case SERVICE_MANAGER_AUTO, SERVICE_MANAGER_SYSTEMD, SERVICE_MANAGER_OPENRC, SERVICE_MANAGER_SYSV, SERVICE_MANAGER_NONE:
+		return true
+	default:
+		return false
+	}
+}
+
+// detectServiceManager identifie le système d'init actif
+func detectServiceManager() string {
+	// /run/systemd/system n'existe que si systemd est le gestionnaire d'init actif
+	if _, err := os.Stat("/run/systemd/system"); err == nil {
+		return SERVICE_MANAGER_SYSTEMD
+	}
+	if _, err := os.Stat("/run/openrc"); err == nil {
+		return SERVICE_MANAGER_OPENRC
+	}
+	if _, err := os.Stat("/sbin/openrc-run"); err == nil {
+		return SERVICE_MANAGER_OPENRC
+	}
+	// Un PID 1 "init" avec /etc/init.d : init System V (ou compatible)
+	if comm, err := os.ReadFile("/proc/1/comm"); err == nil && strings.TrimSpace(string(comm)) == "init" {
+		if _, err := os.Stat("/etc/init.d"); err == nil {
+			return SERVICE_MANAGER_SYSV
+		}
+	}
+	// Aucun init reconnu : conteneur ou supervision externe
+	return SERVICE_MANAGER_NONE
+}
+
+// saveServiceManagerChoice mémorise le gestionnaire utilisé pour les commandes
+// ultérieures (config set, verify, doctor...)
+func saveServiceManagerChoice(name string) error {
+	stateDir, err := ensureStateDirectory()
+	if err != nil {
+		return err
+	}
+	return writeBytesAtomic(filepath.Join(stateDir, SERVICE_MANAGER_STATE_FILE), []byte(name+"\n"), 0644)
+}
+
+// installedServiceManagerName retourne le gestionnaire choisi à l'installation,
+// ou celui détecté pour une installation antérieure à ce choix
+func installedServiceManagerName() string {
+	if stateDir, err := getStateDirectory(); err == nil {
+		if content, err := os.ReadFile(filepath.Join(stateDir, SERVICE_MANAGER_STATE_FILE)); err == nil {
+			if name := strings.TrimSpace(string(content)); validServiceManager(name) && name != SERVICE_MANAGER_AUTO {
+				return name
+			}
+		}
+	}
+	return detectServiceManager()
+}
+
+// noneServiceManager ne gère aucun service : le binaire et la configuration
+// sont installés, le lancement est laissé à l'appelant (conteneur, supervisord...)
+type noneServiceManager struct{}
+
+func (noneServiceManager) Name() string { return SERVICE_MANAGER_NONE }
+
+func (noneServiceManager) Install(opts installOptions) error {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	attrs := []any{"command", fmt.Sprintf("%s --config=%s", getBinaryPath(), configPath)}
+	if envPath, err := getEnvFilePath(); err == nil {
+		if _, err := os.Stat(envPath); err == nil {
+			// Endpoint et jeton du Gateway à charger dans l'environnement
+			attrs = append(attrs, "env_file", envPath)
+		}
+	}
+	slog.Warn("Aucun gestionnaire de service (--service-manager none) : fichiers installés uniquement, lancez le collector avec la commande indiquée", attrs...)
+	return nil
+}
+
+func (noneServiceManager) Start() error { return nil }
+
+func (noneServiceManager) Stop() error { return nil }
+
+func (noneServiceManager) Status() (serviceState, error) {
+	return serviceState{State: "non géré"}, nil
+}
+
+func (noneServiceManager) Uninstall() error { return nil }
@@ -0,0 +1,289 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// newServiceManager retourne le gestionnaire de service demandé, ou celui
+// détecté sur le système pour "auto"
+func newServiceManager(name string, wait serviceWait) (ServiceManager, error) {
+	if userMode && name != SERVICE_MANAGER_SYSTEMD && name != SERVICE_MANAGER_AUTO && name != "" {
+		return nil, fmt.Errorf("le mode utilisateur nécessite systemd (gestionnaire demandé : %s)", name)
+	}
+	if userMode {
+		name = SERVICE_MANAGER_SYSTEMD
+	}
+	if name == "" || name == SERVICE_MANAGER_AUTO {
+		name = detectServiceManager()
+		slog.Info("Système d'init détecté", "service_manager", name)
+	}
+
+	switch name {
+	case SERVICE_MANAGER_SYSTEMD:
+		return &systemdManager{wait: wait}, nil
+	case SERVICE_MANAGER_OPENRC:
+		return &openrcManager{wait: wait}, nil
+	case SERVICE_MANAGER_SYSV:
+		return &sysvManager{wait: wait}, nil
+	case SERVICE_MANAGER_NONE:
+		return noneServiceManager{}, nil
+	default:
+		return nil, fmt.Errorf("gestionnaire de service inconnu : %s", name)
+	}
+}
+
+// installLinuxService installe le service avec le gestionnaire choisi puis le
+// démarre ; la configuration précédente est restaurée si le collector échoue
+func installLinuxService(opts installOptions) error {
+	manager, err := newServiceManager(opts.ServiceManager, serviceWait{ReadyTimeout: opts.ReadyTimeout, JournalLines: opts.JournalLines})
+	if err != nil {
+		return err
+	}
+
+	if err := manager.Install(opts); err != nil {
+		return err
+	}
+	if err := saveServiceManagerChoice(manager.Name()); err != nil {
+		slog.Warn("Impossible de mémoriser le gestionnaire de service", "error", err)
+	}
+	if manager.Name() == SERVICE_MANAGER_NONE {
+		return nil
+	}
+
+	// Démarrer le service (restart pour prendre en compte une ré-installation)
+	slog.Info("Démarrage du service", "service", SERVICE_NAME)
+	if err := restartWithRollback(manager.Start); err != nil {
+		return err
+	}
+
+	slog.Info("Service installé et démarré", "service_manager", manager.Name())
+	return nil
+}
+
+// restartLinuxService redémarre le service avec le gestionnaire utilisé à
+// l'installation et attend que le collector soit opérationnel
+func restartLinuxService(timeout time.Duration, journalLines int) error {
+	manager, err := newServiceManager(installedServiceManagerName(), serviceWait{ReadyTimeout: timeout, JournalLines: journalLines})
+	if err != nil {
+		return err
+	}
+	return manager.Start()
+}
+
+// reloadableLinuxService retourne l'unité systemd si la configuration peut
+// être rechargée sans redémarrage : collector exécuté directement (le client
+// docker ou podman ne relaie pas SIGHUP de façon fiable) et ExecReload défini
+func reloadableLinuxService(timeout time.Duration, journalLines int) (*systemdManager, bool) {
+	if installedServiceManagerName() != SERVICE_MANAGER_SYSTEMD {
+		return nil, false
+	}
+	if mode, _ := installedRuntime(); mode != RUNTIME_BINARY {
+		return nil, false
+	}
+	manager := &systemdManager{wait: serviceWait{ReadyTimeout: timeout, JournalLines: journalLines}}
+	return manager, manager.CanReload()
+}
+
+// reloadLinuxService recharge la configuration du collector ; si le
+// rechargement est impossible, le service est redémarré
+func reloadLinuxService(timeout time.Duration, journalLines int) error {
+	manager, ok := reloadableLinuxService(timeout, journalLines)
+	if !ok {
+		slog.Info("Rechargement à chaud indisponible pour ce service : redémarrage")
+		return restartLinuxService(timeout, journalLines)
+	}
+	return manager.Reload()
+}
+
+// isLinuxServiceActive indique si le service de l'agent est actif
+func isLinuxServiceActive() bool {
+	manager, err := newServiceManager(installedServiceManagerName(), serviceWait{})
+	if err != nil {
+		return false
+	}
+	state, err := manager.Status()
+	return err == nil && state.Active
+}
+
+// prepareServiceAccount crée l'utilisateur de service, protège le fichier
+// d'environnement, crée le répertoire de logs et lui confie celui de
+// l'opamp-supervisor (commun à tous les gestionnaires)
+func prepareServiceAccount() error {
+	// En mode utilisateur, tout appartient déjà à l'utilisateur courant
+	if userMode {
+		return createLogDirectory()
+	}
+
+	// Créer l'utilisateur système
+	if err := createSystemUser(); err != nil {
+		return fmt.Errorf("échec création utilisateur : %w", err)
+	}
+
+	// Le fichier d'environnement contient des secrets : root:smartsentry, 0600
+	envPath, err := getEnvFilePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(envPath); err == nil {
+		if err := secureEnvFile(envPath, "smartsentry"); err != nil {
+			return fmt.Errorf("échec sécurisation de %s : %w", envPath, err)
+		}
+	}
+
+	// Créer le répertoire de logs
+	if err := createLogDirectory(); err != nil {
+		return fmt.Errorf("échec création répertoire logs : %w", err)
+	}
+
+	// L'opamp-supervisor écrit la configuration distante et son état
+	if err := secureSupervisorStorage(); err != nil {
+		return fmt.Errorf("échec préparation du répertoire de l'opamp-supervisor : %w", err)
+	}
+	return nil
+}
+
+// initScriptParams retourne les paramètres de rendu des scripts OpenRC et SysV
+func initScriptParams() (unitParams, error) {
+	params := defaultUnitParams()
+	if envPath, err := getEnvFilePath(); err == nil {
+		if _, err := os.Stat(envPath); err == nil {
+			params.EnvironmentFile = envPath
+		}
+	}
+
+	// Sans systemd, les groupes requis sont accordés par appartenance de
+	// l'utilisateur ; les capabilities ne peuvent pas être attribuées
+	config, err := os.ReadFile(params.ConfigPath)
+	if err != nil {
+		return params, fmt.Errorf("impossible de lire %s : %w", params.ConfigPath, err)
+	}
+	requirements, err := analyzePrivileges(config)
+	if err != nil {
+		return params, fmt.Errorf("impossible d'analyser la configuration : %w", err)
+	}
+	printPrivilegeRequirements(requirements)
+	capabilities, groups := mergePrivileges(requirements)
+	if len(capabilities) > 0 {
+		slog.Warn("Capabilities non attribuables sans systemd : certaines métriques de processus seront incomplètes", "capabilities", strings.Join(capabilities, ", "))
+	}
+	for _, group := range groups {
+		if err := addUserToGroup(params.User, group); err != nil {
+			slog.Warn("Impossible d'ajouter l'utilisateur au groupe", "user", params.User, "group", group, "error", err)
+		}
+	}
+	return params, nil
+}
+
+// addUserToGroup ajoute l'utilisateur de service à un groupe existant
+func addUserToGroup(user, group string) error {
+	if err := runSystemCommand("getent", "group", group); err != nil {
+		return fmt.Errorf("groupe absent du système")
+	}
+	if _, err := exec.LookPath("usermod"); err == nil {
+		return runSystemCommand("usermod", "-a", "-G", group, user)
+	}
+	// BusyBox (Alpine)
+	return runSystemCommand("addgroup", user, group)
+}
+
+// writeInitScript génère un script d'init depuis le modèle embarqué
+func writeInitScript(templateName string, params unitParams) error {
+	content, err := renderServiceTemplate(templateName, params)
+	if err != nil {
+		return fmt.Errorf("impossible de générer le script %s : %w", templateName, err)
+	}
+
+	// Tout fichier de /etc/init.d est un service pour OpenRC et SysV : pas de
+	// copie .bak à côté du script, qui est de toute façon régénéré à chaque
+	// installation (et suppression de celle laissée par une version antérieure)
+	path := initScriptPath()
+	slog.Info("Création du script de service", "path", path)
+	if err := writeBytesAtomicWithoutBackup(path, content, 0755); err != nil {
+		return fmt.Errorf("impossible d'écrire %s : %w", path, err)
+	}
+	if err := os.Remove(path + ".bak"); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Impossible de supprimer l'ancienne copie du script", "path", path+".bak", "error", err)
+	}
+	return nil
+}
+
+// waitForCollector attend que le collector lancé par un gestionnaire sans
+// état détaillé (OpenRC, SysV) soit opérationnel
+func waitForCollector(timeout time.Duration, running func() bool) error {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(configPath)
+	useHealthCheck := err == nil && configHasHealthCheck(content)
+
+	if useHealthCheck {
+		slog.Info("Attente du health_check", "endpoint", HEALTH_CHECK_ENDPOINT, "timeout", timeout)
+	} else {
+		slog.Warn("Extension health_check absente de la configuration : attente d'un fonctionnement stable", "period", READY_STABLE_PERIOD)
+	}
+
+	deadline := time.Now().Add(timeout)
+	startedAt := time.Now()
+	for {
+		if !running() {
+			return fmt.Errorf("le processus du collector s'est arrêté")
+		}
+
+		var health healthStatus
+		if useHealthCheck {
+			health = probeHealth()
+			if health.Healthy {
+				slog.Info("Service actif et collector opérationnel", "service", SERVICE_NAME, "health", health.Status)
+				return nil
+			}
+		} else if time.Since(startedAt) >= READY_STABLE_PERIOD {
+			slog.Info("Service actif et stable", "service", SERVICE_NAME, "period", READY_STABLE_PERIOD)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			reason := health.Error
+			if reason == "" {
+				reason = health.Status
+			}
+			return fmt.Errorf("collector non opérationnel après %s (health_check: %s)", timeout, reason)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// logLogFileExcerpt journalise la fin du journal du collector (OpenRC, SysV)
+// avec la cause probable de l'échec
+func logLogFileExcerpt(lines int) {
+	f, err := os.Open(COLLECTOR_LOG_FILE)
+	if err != nil {
+		slog.Warn("Journal indisponible", "path", COLLECTOR_LOG_FILE)
+		return
+	}
+	defer f.Close()
+
+	// Seule la fin du fichier est utile
+	if info, err := f.Stat(); err == nil && info.Size() > 64<<10 {
+		f.Seek(-64<<10, io.SeekEnd)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		slog.Warn("Journal illisible", "path", COLLECTOR_LOG_FILE)
+		return
+	}
+
+	all := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	logJournalExcerpt(strings.Join(all, "\n"))
+}
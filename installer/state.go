@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// getStateDirectory retourne le répertoire d'état de l'installateur (dernière
+// configuration valide, configurations en échec...)
+func getStateDirectory() (string, error) {
+	switch runtime.GOOS {
+	case "linux", "darwin":
+		if userMode {
+			return userStatePath("smartsentry-agent"), nil
+		}
+		return "/var/lib/smartsentry-agent", nil
+	case "windows":
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			return "", fmt.Errorf("variable d'environnement ProgramData non définie")
+		}
+		return filepath.Join(programData, "SmartSentry", "State"), nil
+	default:
+		return "", fmt.Errorf("système d'exploitation non supporté : %s", runtime.GOOS)
+	}
+}
+
+// ensureStateDirectory crée si besoin un sous-répertoire privé de l'état
+func ensureStateDirectory(elem ...string) (string, error) {
+	stateDir, err := getStateDirectory()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(append([]string{stateDir}, elem...)...)
+	// Les copies de agent.env contiennent des secrets : accès réservé à root
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("impossible de créer %s : %w", dir, err)
+	}
+	return dir, nil
+}
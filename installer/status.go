@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Adresses locales exposées par le collector : extension health_check et
+	// télémétrie interne au format Prometheus
+	HEALTH_CHECK_ENDPOINT = "127.0.0.1:13133"
+	TELEMETRY_ENDPOINT    = "127.0.0.1:8888"
+
+	// Délai maximal des requêtes vers les endpoints locaux du collector
+	LOCAL_PROBE_TIMEOUT = 3 * time.Second
+)
+
+// statusMetrics associe les métriques de télémétrie interne du collector
+// (sans suffixe _total) aux libellés affichés
+var statusMetrics = []struct {
+	name  string
+	label string
+}{
+	{"otelcol_receiver_accepted_metric_points", "Points acceptés"},
+	{"otelcol_receiver_refused_metric_points", "Points refusés"},
+	{"otelcol_exporter_sent_metric_points", "Points envoyés"},
+	{"otelcol_exporter_send_failed_metric_points", "Échecs d'envoi"},
+	{"otelcol_exporter_queue_size", "File d'attente"},
+	{"otelcol_exporter_queue_capacity", "Capacité de la file"},
+}
+
+// unitStatus décrit l'état de l'unité systemd
+type unitStatus struct {
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state"`
+	Restarts    int    `json:"restarts"`
+	MainPID     int    `json:"main_pid"`
+	Uptime      string `json:"uptime,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// healthStatus décrit la réponse de l'extension health_check
+type healthStatus struct {
+	Healthy bool   `json:"healthy"`
+	Status  string `json:"status,omitempty"`
+	UpSince string `json:"up_since,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// telemetryStatus regroupe les compteurs de débit d'export
+type telemetryStatus struct {
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// statusReport est le rapport de la commande status
+type statusReport struct {
+	Service   string          `json:"service"`
+	Unit      *unitStatus     `json:"unit,omitempty"`
+	Health    healthStatus    `json:"health"`
+	Telemetry telemetryStatus `json:"telemetry"`
+}
+
+// runStatus implémente la commande status : état du service, santé du
+// collector et débit d'export
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "produire le rapport au format JSON")
+	fs.Parse(args)
+
+	report := statusReport{Service: SERVICE_NAME}
+	if runtime.GOOS == "linux" && installedServiceManagerName() == SERVICE_MANAGER_SYSTEMD {
+		unit := readUnitStatus()
+		report.Unit = &unit
+	}
+	report.Health = probeHealth()
+	report.Telemetry = scrapeTelemetry()
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printStatusReport(report)
+	}
+
+	if report.Unit != nil && report.Unit.Error != "" {
+		return fmt.Errorf("état du service %s inconnu : %s", SERVICE_NAME, report.Unit.Error)
+	}
+	if report.Unit != nil && report.Unit.ActiveState != "active" {
+		return fmt.Errorf("service %s non actif (%s)", SERVICE_NAME, report.Unit.ActiveState)
+	}
+	if !report.Health.Healthy {
+		return fmt.Errorf("le collector ne répond pas sain")
+	}
+	return nil
+}
+
+// printStatusReport affiche le rapport de statut sous forme lisible sur
+// stdout, comme résultat de la commande (voir loggingOptions)
+func printStatusReport(report statusReport) {
+	fmt.Printf("📊 Statut de %s\n\n", report.Service)
+
+	if unit := report.Unit; unit != nil {
+		fmt.Println("🔧 Service systemd")
+		if unit.Error != "" {
+			fmt.Printf("  ❌ %s\n", unit.Error)
+		} else {
+			icon := "✅"
+			if unit.ActiveState != "active" {
+				icon = "❌"
+			}
+			fmt.Printf("  %s État        : %s (%s)\n", icon, unit.ActiveState, unit.SubState)
+			fmt.Printf("  • PID         : %d\n", unit.MainPID)
+			fmt.Printf("  • Redémarrages: %d\n", unit.Restarts)
+			if unit.Uptime != "" {
+				fmt.Printf("  • Uptime      : %s\n", unit.Uptime)
+			}
+		}
+		fmt.Println()
+	}
+
+	fmt.Println("💓 Santé du collector (health_check)")
+	if report.Health.Healthy {
+		fmt.Printf("  ✅ %s", report.Health.Status)
+		if report.Health.UpSince != "" {
+			fmt.Printf(" depuis %s", report.Health.UpSince)
+		}
+		fmt.Println()
+	} else if report.Health.Error != "" {
+		fmt.Printf("  ❌ %s\n", report.Health.Error)
+	} else {
+		fmt.Printf("  ❌ %s\n", report.Health.Status)
+	}
+	fmt.Println()
+
+	fmt.Println("📤 Débit d'export (télémétrie interne)")
+	if report.Telemetry.Error != "" {
+		fmt.Printf("  ❌ %s\n", report.Telemetry.Error)
+		return
+	}
+	for _, metric := range statusMetrics {
+		value, ok := report.Telemetry.Metrics[metric.name]
+		if !ok {
+			fmt.Printf("  • %-20s: n/a\n", metric.label)
+			continue
+		}
+		fmt.Printf("  • %-20s: %s\n", metric.label, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+}
+
+// readUnitStatus interroge systemd sur l'état de l'unité
+func readUnitStatus() unitStatus {
+	// systemctl affiche les dates dans le fuseau de son environnement avec une
+	// abréviation (CEST, IST...) dont le décalage est ambigu ou inconnu de
+	// Go : les demander en UTC pour calculer une durée exacte
+	output, err := commandOutput("env", append([]string{"TZ=UTC", "systemctl"}, systemctlArgs("show", SERVICE_NAME,
+		"--property=ActiveState,SubState,NRestarts,MainPID,ActiveEnterTimestamp")...)...)
+	if err != nil {
+		return unitStatus{Error: fmt.Sprintf("systemctl show a échoué : %v", err)}
+	}
+
+	properties := parseSystemdProperties(output)
+	status := unitStatus{
+		ActiveState: properties["ActiveState"],
+		SubState:    properties["SubState"],
+	}
+	status.Restarts, _ = strconv.Atoi(properties["NRestarts"])
+	status.MainPID, _ = strconv.Atoi(properties["MainPID"])
+
+	if status.ActiveState == "active" {
+		if since, ok := parseSystemdTimestamp(properties["ActiveEnterTimestamp"]); ok {
+			status.Uptime = time.Since(since).Round(time.Second).String()
+		}
+	}
+
+	return status
+}
+
+// parseSystemdTimestamp analyse une date systemd affichée en UTC
+// ("Sat 2026-10-18 12:00:00 UTC")
+func parseSystemdTimestamp(value string) (time.Time, bool) {
+	value, ok := strings.CutSuffix(strings.TrimSpace(value), " UTC")
+	if !ok {
+		return time.Time{}, false
+	}
+	since, err := time.ParseInLocation("Mon 2006-01-02 15:04:05", value, time.UTC)
+	return since, err == nil
+}
+
+// parseSystemdProperties analyse la sortie "Clé=Valeur" de systemctl show
+func parseSystemdProperties(output string) map[string]string {
+	properties := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			properties[key] = value
+		}
+	}
+	return properties
+}
+
+// probeHealth interroge l'extension health_check du collector
+func probeHealth() healthStatus {
+	client := &http.Client{Timeout: LOCAL_PROBE_TIMEOUT}
+	resp, err := client.Get("http://" + HEALTH_CHECK_ENDPOINT + "/")
+	if err != nil {
+		return healthStatus{Error: fmt.Sprintf("health_check injoignable : %v", err)}
+	}
+	defer resp.Body.Close()
+
+	// Réponse type : {"status":"Server available","upSince":"...","uptime":"..."}
+	var body struct {
+		Status  string `json:"status"`
+		UpSince string `json:"upSince"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+
+	status := healthStatus{
+		Healthy: resp.StatusCode == http.StatusOK,
+		Status:  body.Status,
+		UpSince: body.UpSince,
+	}
+	if status.Status == "" {
+		status.Status = resp.Status
+	}
+	return status
+}
+
+// scrapeTelemetry lit les métriques Prometheus exposées par le collector et
+// additionne les séries des métriques de débit
+func scrapeTelemetry() telemetryStatus {
+	client := &http.Client{Timeout: LOCAL_PROBE_TIMEOUT}
+	resp, err := client.Get("http://" + TELEMETRY_ENDPOINT + "/metrics")
+	if err != nil {
+		return telemetryStatus{Error: fmt.Sprintf("télémétrie injoignable : %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return telemetryStatus{Error: fmt.Sprintf("télémétrie : mauvais code de statut %d", resp.StatusCode)}
+	}
+
+	wanted := map[string]bool{}
+	for _, metric := range statusMetrics {
+		wanted[metric.name] = true
+	}
+
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, value, ok := parsePrometheusSample(scanner.Text())
+		if !ok {
+			continue
+		}
+		// Les compteurs sont suffixés _total selon la version du collector
+		name = strings.TrimSuffix(name, "_total")
+		if wanted[name] {
+			metrics[name] += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return telemetryStatus{Error: fmt.Sprintf("lecture de la télémétrie : %v", err)}
+	}
+
+	return telemetryStatus{Metrics: metrics}
+}
+
+// parsePrometheusSample analyse une ligne d'échantillon au format texte
+// Prometheus : nom{labels} valeur [timestamp]
+func parsePrometheusSample(line string) (string, float64, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", 0, false
+	}
+
+	name := line
+	rest := ""
+	if i := strings.IndexAny(line, "{ "); i >= 0 {
+		name = line[:i]
+		rest = line[i:]
+	}
+	if strings.HasPrefix(rest, "{") {
+		end := strings.LastIndex(rest, "}")
+		if end < 0 {
+			return "", 0, false
+		}
+		rest = rest[end+1:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return name, value, true
+}
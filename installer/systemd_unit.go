@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	// Répertoire des unités systemd installées par l'administrateur
+	SYSTEMD_UNIT_DIR = "/etc/systemd/system"
+
+	// Drop-in géré par l'installateur pour les limites de ressources ; les
+	// autres fichiers du répertoire .d (ex: override.conf) ne sont jamais touchés
+	SYSTEMD_DROPIN_NAME = "50-smartsentry-installer.conf"
+)
+
+//go:embed templates/*.tmpl
+var serviceTemplateFiles embed.FS
+
+// unitTemplates contient les modèles "unit" et "dropin" (systemd), "openrc" et "sysv"
+var unitTemplates = template.Must(template.New("service").
+	Funcs(template.FuncMap{"join": strings.Join, "base": filepath.Base}).
+	ParseFS(serviceTemplateFiles, "templates/*.tmpl"))
+
+// unitParams regroupe les paramètres de rendu des fichiers de service
+type unitParams struct {
+	ServiceName     string
+	DropInDir       string
+	BinaryPath      string
+	ConfigPath      string
+	User            string
+	Group           string
+	ReadWritePaths  []string
+	EnvironmentFile string
+
+	// Journal du collector pour les gestionnaires sans journal (OpenRC, SysV)
+	LogFile string
+
+	// Droits ciblés requis par les receivers privilégiés
+	AmbientCapabilities []string
+	SupplementaryGroups []string
+
+	// Ajoute les directives de sandboxing systemd avancées
+	Hardened bool
+
+	// Unité systemd --user : ni User=, ni sandboxing (réservés au gestionnaire système)
+	UserMode bool
+
+	// opamp-supervisor qui lance le collector (--opamp-endpoint), et sa configuration
+	SupervisorPath       string
+	SupervisorConfigPath string
+
+	// Moteur (docker, podman) et arguments de "run" lorsque le collector est
+	// exécuté en conteneur (--runtime container)
+	ContainerEngine string
+	ContainerArgs   []string
+
+	// Limites de ressources, écrites dans le drop-in
+	MemoryMax   string
+	CPUQuota    string
+	LimitNOFILE string
+}
+
+// defaultUnitParams retourne les paramètres de l'unité pour l'installation standard
+func defaultUnitParams() unitParams {
+	configPath, err := getConfigPath()
+	if err != nil {
+		configPath = "/etc/smartsentry-agent/config.yaml"
+	}
+
+	params := unitParams{
+		ServiceName:    SERVICE_NAME,
+		DropInDir:      systemdDropInDir(),
+		BinaryPath:     getBinaryPath(),
+		ConfigPath:     configPath,
+		User:           "smartsentry",
+		Group:          "smartsentry",
+		ReadWritePaths: []string{getLogDirectory()},
+		LogFile:        COLLECTOR_LOG_FILE,
+		UserMode:       userMode,
+	}
+
+	// Agent supervisé : le service lance l'opamp-supervisor, qui écrit la
+	// configuration distante et la configuration effective dans son répertoire
+	if supervised() {
+		supervisorConfigPath, _ := getSupervisorConfigPath()
+		storageDir, err := getSupervisorStorageDirectory()
+		if err == nil {
+			params.SupervisorPath = getSupervisorPath()
+			params.SupervisorConfigPath = supervisorConfigPath
+			params.ReadWritePaths = append(params.ReadWritePaths, storageDir)
+		}
+	}
+	return params
+}
+
+// hasResourceLimits indique si des limites de ressources ont été demandées
+func (p unitParams) hasResourceLimits() bool {
+	return p.MemoryMax != "" || p.CPUQuota != "" || p.LimitNOFILE != ""
+}
+
+// systemdUnitPath retourne le chemin de l'unité principale
+func systemdUnitPath() string {
+	if userMode {
+		return userConfigPath("systemd", "user", SERVICE_NAME+".service")
+	}
+	return filepath.Join(SYSTEMD_UNIT_DIR, SERVICE_NAME+".service")
+}
+
+// initScriptPath retourne le chemin du script d'init OpenRC ou SysV
+func initScriptPath() string {
+	return filepath.Join("/etc/init.d", SERVICE_NAME)
+}
+
+// systemdDropInDir retourne le répertoire des drop-ins de l'unité
+func systemdDropInDir() string {
+	return systemdUnitPath() + ".d"
+}
+
+// renderSystemdUnit produit le contenu de l'unité principale
+func renderSystemdUnit(params unitParams) ([]byte, error) {
+	return renderServiceTemplate("unit", params)
+}
+
+// renderSystemdDropIn produit le contenu du drop-in des limites de ressources
+func renderSystemdDropIn(params unitParams) ([]byte, error) {
+	return renderServiceTemplate("dropin", params)
+}
+
+// renderServiceTemplate exécute l'un des modèles de fichiers de service
+func renderServiceTemplate(name string, params interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := unitTemplates.ExecuteTemplate(&buf, name, params); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
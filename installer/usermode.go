@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+)
+
+// userMode active l'installation par utilisateur (--user-mode) : binaire,
+// configuration et unité systemd --user dans le répertoire personnel, sans
+// privilèges administrateur. Détecté automatiquement pour les autres
+// commandes lancées hors root lorsqu'une installation utilisateur existe.
+var userMode bool
+
+// userHomePath retourne un chemin sous le répertoire personnel
+func userHomePath(elem ...string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(append([]string{home}, elem...)...)
+}
+
+// userConfigPath retourne un chemin sous ~/.config (ou $XDG_CONFIG_HOME)
+func userConfigPath(elem ...string) string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = userHomePath(".config")
+	}
+	return filepath.Join(append([]string{configDir}, elem...)...)
+}
+
+// userStatePath retourne un chemin sous ~/.local/state (ou $XDG_STATE_HOME)
+func userStatePath(elem ...string) string {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		stateDir = userHomePath(".local", "state")
+	}
+	return filepath.Join(append([]string{stateDir}, elem...)...)
+}
+
+// detectUserMode indique si une installation utilisateur existe pour
+// l'utilisateur courant (hors root)
+func detectUserMode() bool {
+	if runtime.GOOS != "linux" || os.Geteuid() == 0 {
+		return false
+	}
+	_, err := os.Stat(userConfigPath("smartsentry-agent", "config.yaml"))
+	return err == nil
+}
+
+// hasRequiredPrivileges indique si la commande peut modifier l'installation :
+// en mode utilisateur tous les fichiers appartiennent à l'utilisateur
+func hasRequiredPrivileges() bool {
+	return userMode || hasAdminPrivileges()
+}
+
+// systemctlArgs ajoute --user aux arguments de systemctl en mode utilisateur
+func systemctlArgs(args ...string) []string {
+	if userMode {
+		return append([]string{"--user"}, args...)
+	}
+	return args
+}
+
+// journalctlUnitArgs retourne la sélection de l'unité pour journalctl
+func journalctlUnitArgs() []string {
+	if userMode {
+		return []string{"--user-unit", SERVICE_NAME}
+	}
+	return []string{"-u", SERVICE_NAME}
+}
+
+// getLogDirectory retourne le répertoire de logs de l'agent sous Linux
+func getLogDirectory() string {
+	if userMode {
+		return userStatePath("smartsentry-agent", "log")
+	}
+	return "/var/log/smartsentry-agent"
+}
+
+// enableLinger maintient le gestionnaire systemd de l'utilisateur après sa
+// déconnexion, pour que l'agent continue de tourner et démarre au boot
+func enableLinger() {
+	current, err := user.Current()
+	if err != nil {
+		slog.Warn("Utilisateur courant inconnu", "error", err)
+		return
+	}
+
+	if err := runSystemCommand("loginctl", "enable-linger", current.Username); err != nil {
+		// La politique polkit peut réserver cette action à un administrateur
+		slog.Warn("Lingering non activé : l'agent s'arrêtera à la déconnexion de l'utilisateur ; demandez à un administrateur d'exécuter la commande",
+			"user", current.Username, "command", "sudo loginctl enable-linger "+current.Username)
+		return
+	}
+	slog.Info("Lingering activé : l'agent tourne hors session", "user", current.Username)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// fileDrift décrit les écarts constatés entre un fichier et le manifeste
+type fileDrift struct {
+	Path     string   `json:"path"`
+	Role     string   `json:"role"`
+	Problems []string `json:"problems,omitempty"`
+	Repaired bool     `json:"repaired,omitempty"`
+	Error    string   `json:"error,omitempty"`
+
+	missing  bool
+	modified bool
+}
+
+// runVerify compare les fichiers installés au manifeste d'installation et,
+// avec --repair, restaure les fichiers modifiés ou manquants
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "restaurer les fichiers modifiés ou manquants depuis leur source enregistrée")
+	jsonOutput := fs.Bool("json", false, "produire le rapport au format JSON")
+	fs.Parse(args)
+
+	if *repair && !hasRequiredPrivileges() {
+		return fmt.Errorf("privilèges administrateur requis pour --repair")
+	}
+
+	manifest, err := readInstallManifest()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("aucun manifeste d'installation (agent installé par une version antérieure ? relancez install)")
+		}
+		return err
+	}
+
+	var drifts []fileDrift
+	for _, entry := range manifest.Files {
+		drifts = append(drifts, checkManifestEntry(entry))
+	}
+
+	if *repair {
+		// La progression de la réparation est journalisée (stderr et
+		// installer.log) : stdout reste réservé au rapport
+		repairDrifts(manifest, drifts)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(drifts); err != nil {
+			return err
+		}
+	} else {
+		printDriftReport(manifest, drifts)
+	}
+
+	remaining := 0
+	for _, drift := range drifts {
+		if len(drift.Problems) > 0 && !drift.Repaired {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d fichier(s) différent(s) du manifeste d'installation", remaining)
+	}
+	return nil
+}
+
+// checkManifestEntry compare un fichier à son état enregistré
+func checkManifestEntry(entry manifestEntry) fileDrift {
+	drift := fileDrift{Path: entry.Path, Role: entry.Role}
+
+	info, err := os.Stat(entry.Path)
+	if os.IsNotExist(err) {
+		drift.missing = true
+		drift.Problems = append(drift.Problems, "fichier manquant")
+		return drift
+	}
+	if err != nil {
+		drift.Problems = append(drift.Problems, fmt.Sprintf("illisible : %v", err))
+		return drift
+	}
+
+	hash, err := fileSHA256(entry.Path)
+	if err != nil {
+		drift.Problems = append(drift.Problems, fmt.Sprintf("illisible : %v", err))
+		return drift
+	}
+	if hash != entry.SHA256 {
+		drift.modified = true
+		drift.Problems = append(drift.Problems, fmt.Sprintf("contenu modifié (sha256 %.12s… au lieu de %.12s…)", hash, entry.SHA256))
+	}
+
+	if mode := formatFileMode(info.Mode()); mode != entry.Mode {
+		drift.Problems = append(drift.Problems, fmt.Sprintf("mode %s au lieu de %s", mode, entry.Mode))
+	}
+
+	if entry.UID != nil && entry.GID != nil {
+		if uid, gid, ok := fileOwner(info); ok && (uid != *entry.UID || gid != *entry.GID) {
+			drift.Problems = append(drift.Problems, fmt.Sprintf("propriétaire %d:%d au lieu de %d:%d", uid, gid, *entry.UID, *entry.GID))
+		}
+	}
+	return drift
+}
+
+// repairDrifts restaure les fichiers en écart puis recharge et redémarre le
+// service si nécessaire
+func repairDrifts(manifest *installManifest, drifts []fileDrift) {
+	entries := map[string]manifestEntry{}
+	for _, entry := range manifest.Files {
+		entries[entry.Path] = entry
+	}
+
+	repaired := map[string]bool{}
+	for i := range drifts {
+		drift := &drifts[i]
+		if len(drift.Problems) == 0 {
+			continue
+		}
+		if err := repairFile(entries[drift.Path], drift.missing || drift.modified, manifest.OTelVersion); err != nil {
+			drift.Error = err.Error()
+			slog.Warn("Réparation impossible", "path", drift.Path, "role", drift.Role, "error", err)
+			continue
+		}
+		slog.Info("Fichier réparé", "path", drift.Path, "role", drift.Role)
+		drift.Repaired = true
+		repaired[drift.Role] = true
+	}
+
+	if len(repaired) == 0 || runtime.GOOS != "linux" {
+		return
+	}
+
+	if repaired[ROLE_UNIT] || repaired[ROLE_DROPIN] {
+		if err := runSystemCommand("systemctl", systemctlArgs("daemon-reload")...); err != nil {
+			slog.Warn("Échec du rechargement de systemd", "error", err)
+		}
+	}
+	if err := restartAgentService(60*time.Second, 30); err != nil {
+		slog.Warn("Échec du redémarrage après réparation", "error", err)
+	}
+}
+
+// repairFile restaure le contenu (si nécessaire), le mode et le propriétaire d'un fichier
+func repairFile(entry manifestEntry, restoreContent bool, otelVersion string) error {
+	mode, err := parseFileMode(entry.Mode)
+	if err != nil {
+		return err
+	}
+
+	if restoreContent {
+		if entry.Role == ROLE_BINARY || entry.Role == ROLE_SUPERVISOR {
+			// Les binaires ne sont pas copiés : ils sont retéléchargés depuis leur source
+			if otelVersion != OTEL_VERSION {
+				return fmt.Errorf("binaire enregistré en version %s, cet installateur fournit la %s : relancez install", otelVersion, OTEL_VERSION)
+			}
+			slog.Info("Retéléchargement du binaire", "url", entry.Source)
+			download := downloadOTelCollector
+			if entry.Role == ROLE_SUPERVISOR {
+				download = downloadOpAMPSupervisor
+			}
+			if err := download(); err != nil {
+				return err
+			}
+			if hash, err := fileSHA256(entry.Path); err != nil || hash != entry.SHA256 {
+				return fmt.Errorf("le binaire retéléchargé ne correspond pas à l'empreinte enregistrée")
+			}
+		} else {
+			content, err := os.ReadFile(entry.Source)
+			if err != nil {
+				return fmt.Errorf("source %s illisible : %w", entry.Source, err)
+			}
+			write := writeBytesAtomic
+			if entry.Role == ROLE_INIT {
+				// Pas de copie .bak dans /etc/init.d (voir writeInitScript)
+				write = writeBytesAtomicWithoutBackup
+			}
+			if err := write(entry.Path, content, mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Chmod(entry.Path, mode); err != nil {
+		return err
+	}
+	if entry.UID != nil && entry.GID != nil && runtime.GOOS != "windows" {
+		if err := os.Chown(entry.Path, *entry.UID, *entry.GID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printDriftReport affiche le rapport de vérification sur stdout, comme
+// résultat de la commande (voir loggingOptions)
+func printDriftReport(manifest *installManifest, drifts []fileDrift) {
+	fmt.Printf("🔎 Vérification de l'installation (manifeste du %s)\n\n", manifest.RecordedAt.Local().Format("2006-01-02 15:04:05"))
+
+	for _, drift := range drifts {
+		switch {
+		case len(drift.Problems) == 0:
+			fmt.Printf("✅ %-8s %s\n", drift.Role, drift.Path)
+		case drift.Repaired:
+			fmt.Printf("🔧 %-8s %s : réparé — %s\n", drift.Role, drift.Path, strings.Join(drift.Problems, ", "))
+		default:
+			fmt.Printf("❌ %-8s %s : %s\n", drift.Role, drift.Path, strings.Join(drift.Problems, ", "))
+			if drift.Error != "" {
+				fmt.Printf("   ↳ réparation impossible : %s\n", drift.Error)
+			}
+		}
+	}
+}
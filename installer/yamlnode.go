@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAMLDocument analyse un document YAML en conservant ses commentaires
+func parseYAMLDocument(content []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("configuration YAML invalide : %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("la configuration doit être un objet YAML")
+	}
+	return &doc, nil
+}
+
+// marshalConfigDocument sérialise un document de configuration et rétablit
+// une ligne vide entre les sections de premier niveau, que yaml.v3 ne conserve pas
+func marshalConfigDocument(doc *yaml.Node) ([]byte, error) {
+	data, err := marshalYAML(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var out []string
+	for i, line := range lines {
+		topLevel := line != "" && line[0] != ' ' && line[0] != '-'
+		if i > 0 && topLevel && lines[i-1] != "" && strings.HasPrefix(lines[i-1], " ") {
+			out = append(out, "")
+		}
+		out = append(out, line)
+	}
+	return []byte(strings.Join(out, "\n")), nil
+}
+
+// yamlRoot retourne le mapping racine d'un document
+func yamlRoot(doc *yaml.Node) *yaml.Node {
+	return doc.Content[0]
+}
+
+// yamlGet retourne la valeur associée à key dans un mapping, ou nil
+func yamlGet(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlLookup suit un chemin de clés depuis un mapping, ou retourne nil
+func yamlLookup(mapping *yaml.Node, path ...string) *yaml.Node {
+	node := mapping
+	for _, key := range path {
+		node = yamlGet(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// yamlSet remplace (ou ajoute) la valeur associée à key dans un mapping
+func yamlSet(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			// Conserver les commentaires attachés à l'ancienne valeur
+			value.LineComment = mapping.Content[i+1].LineComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, yamlScalar(key), value)
+}
+
+// yamlDelete supprime key d'un mapping et indique si elle était présente
+func yamlDelete(mapping *yaml.Node, key string) bool {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// yamlEnsureMapping suit un chemin de clés en créant les mappings manquants
+// (une valeur vide comme "extensions:" est convertie en mapping)
+func yamlEnsureMapping(mapping *yaml.Node, path ...string) *yaml.Node {
+	node := mapping
+	for _, key := range path {
+		child := yamlGet(node, key)
+		if child == nil || child.Kind != yaml.MappingNode {
+			child = yamlMapping()
+			yamlSet(node, key, child)
+		}
+		node = child
+	}
+	return node
+}
+
+// yamlAppendUnique ajoute value à la séquence associée à key si elle n'y est pas
+// déjà ; une séquence créée utilise le style [a, b] des configurations livrées
+func yamlAppendUnique(mapping *yaml.Node, key, value string) {
+	seq := yamlGet(mapping, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		yamlSet(mapping, key, seq)
+	}
+	for _, item := range seq.Content {
+		if item.Value == value {
+			return
+		}
+	}
+	seq.Content = append(seq.Content, yamlScalar(value))
+}
+
+// yamlRemoveFromSequence retire value de la séquence associée à key
+func yamlRemoveFromSequence(mapping *yaml.Node, key, value string) {
+	seq := yamlGet(mapping, key)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return
+	}
+	kept := seq.Content[:0]
+	for _, item := range seq.Content {
+		if item.Value != value {
+			kept = append(kept, item)
+		}
+	}
+	seq.Content = kept
+}
+
+// yamlScalar crée un nœud scalaire
+func yamlScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+// yamlQuoted crée un scalaire toujours interprété comme chaîne ("true", "42"...)
+func yamlQuoted(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.DoubleQuotedStyle, Value: value}
+}
+
+// yamlValue retourne la valeur d'un scalaire, ou une chaîne vide
+func yamlValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// yamlMapping crée un mapping à partir de paires clé/valeur scalaires
+func yamlMapping(pairs ...string) *yaml.Node {
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		mapping.Content = append(mapping.Content, yamlScalar(pairs[i]), yamlScalar(pairs[i+1]))
+	}
+	return mapping
+}